@@ -7,6 +7,7 @@ import (
 
 type dumpCmdState struct {
 	IgnoreError bool `help:"Ignore errors"`
+	VerifyCRC   bool `help:"Verify CRC32 of Fluent Bit chunk files, if written with storage.checksum on"`
 }
 
 var dumpCmd = dumpCmdState{}
@@ -15,7 +16,9 @@ func (cmd *dumpCmdState) Run(args []string) {
 	if len(args) < 1 {
 		logger.Fatal("requires at least one file or directory")
 	}
-	err := dump.PrintFileOrDirectories(args, cmd.IgnoreError)
+	err := dump.PrintFileOrDirectoriesWithOptions(args, cmd.IgnoreError, dump.Options{
+		VerifyCRC: cmd.VerifyCRC,
+	})
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -1,32 +1,88 @@
 package cmd
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/relex/fluentlib/server"
 	"github.com/relex/fluentlib/server/receivers"
 	"github.com/relex/gotils/logger"
 )
 
+// shutdownTimeout is the max time to wait for pending messages and acks on shutdown
+const shutdownTimeout = 10 * time.Second
+
 type serverCmdState struct {
 	server.Config
 }
 
 var serverCmd = serverCmdState{
 	Config: server.Config{
-		Address:           "localhost:24224",
-		Secret:            "guess",
-		TLS:               true,
-		SplitOutputKeys:   []string{"app", "level", "pnum"},
-		SplitOutputPath:   "",
-		SplitStrictMode:   false,
-		RandomNoHandshake: 0.0,
-		RandomFailAuth:    0.0,
-		RandomNoReceiving: 0.0,
-		RandomNoResponse:  0.0,
-		RandomKillConn:    0.0,
+		ListenerConfig: server.ListenerConfig{
+			Name:                   "",
+			Address:                "localhost:24224",
+			UnixSocketMode:         "",
+			Secret:                 "guess",
+			Tenants:                nil,
+			TLS:                    true,
+			TLSCertFile:            "",
+			TLSKeyFile:             "",
+			TLSClientCAFile:        "",
+			TLSMinVersion:          "",
+			TLSMaxVersion:          "",
+			TLSCipherSuites:        nil,
+			TLSTestCA:              false,
+			TLSTestCADir:           "",
+			TLSTestClientName:      "",
+			TLSFault:               "",
+			Heartbeat:              false,
+			Users:                  nil,
+			UsersFile:              "",
+			ClientRules:            nil,
+			DenyAnonymousSource:    false,
+			RandomNoHandshake:      0.0,
+			RandomNoHandshakeStall: 60 * time.Second,
+			RandomFailAuth:         0.0,
+			RandomNoReceiving:      0.0,
+			RandomNoReceivingStall: 30 * time.Second,
+			RandomNoResponse:       0.0,
+			RandomKillConn:         0.0,
+			RandomWrongAck:         0.0,
+			RandomDuplicateAck:     0.0,
+			RandomReorderAck:       0.0,
+			RandomPhantomAck:       0.0,
+			RandomMalformedAck:     0.0,
+			RandomDelayAck:         0.0,
+			RandomDelayAckMin:      time.Second,
+			RandomDelayAckMax:      10 * time.Second,
+			RandomDelayAckDist:     "uniform",
+			RandomDropHeartbeat:    0.0,
+			RandomStallJitter:      0.0,
+			HeartbeatDelay:         0,
+		},
+		Listeners:            nil,
+		ListenerSpecs:        nil,
+		HandshakeTimeout:     10 * time.Second,
+		IdleTimeout:          30 * time.Second,
+		ReadTimeout:          30 * time.Second,
+		AckTimeout:           30 * time.Second,
+		ReceiverEndTimeout:   5 * time.Second,
+		RandomSeed:           0,
+		Scenario:             nil,
+		ScenarioFile:         "",
+		LazyEntries:          false,
+		LazyEntriesUnchecked: false,
+		MaxMessageBytes:      0,
+		MaxDecompressedBytes: 0,
+		MaxEntries:           0,
+		MaxNestingDepth:      0,
+		MaxStringLength:      0,
+		SplitOutputKeys:      []string{"app", "level", "pnum"},
+		SplitOutputPath:      "",
+		SplitStrictMode:      false,
 	},
 }
 
@@ -46,17 +102,30 @@ func (cmd *serverCmdState) Run(args []string) {
 		logger.Infof("use message output")
 		receiver = receivers.NewMessageWriter(os.Stdout)
 	}
-	
-	srv, _ := server.LaunchServer(logger.Root(), cmd.Config, receiver)
+
+	srv, err := server.NewServer(logger.Root(), cmd.Config, receiver)
+	if err != nil {
+		logger.Fatal("failed to start server: ", err)
+	}
+	go srv.Serve(context.Background()) // fatal errors are checked after Done
 
 	sigChan := make(chan os.Signal, 10)
 	signal.Notify(sigChan, syscall.SIGINT)
 	signal.Notify(sigChan, syscall.SIGTERM)
 
-	s := <-sigChan
-	logger.Infof("server received %v, stopping", s)
-
-	srv.Shutdown()
+	select {
+	case s := <-sigChan:
+		logger.Infof("server received %v, stopping", s)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("server stopped without draining: ", err)
+		}
+	case <-srv.Done():
+	}
+	if err := srv.Err(); err != nil {
+		logger.Fatal("server failed: ", err)
+	}
 	logger.Info("server stopped")
 	logger.Exit(0)
 }
@@ -15,17 +15,22 @@ import (
 
 // PrintFileOrDirectories prints log records from a list of files or directories of files
 func PrintFileOrDirectories(pathList []string, ignoreError bool) error {
+	return PrintFileOrDirectoriesWithOptions(pathList, ignoreError, Options{VerifyCRC: false})
+}
+
+// PrintFileOrDirectoriesWithOptions is PrintFileOrDirectories with optional checks
+func PrintFileOrDirectoriesWithOptions(pathList []string, ignoreError bool, options Options) error {
 	bufWriter := bufio.NewWriterSize(os.Stdout, 1048576)
 	defer bufWriter.Flush()
 	for _, path := range pathList {
-		if err := printChunkFilesInDir(path, bufWriter, ignoreError); err != nil {
+		if err := printChunkFilesInDir(path, bufWriter, ignoreError, options); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func printChunkFilesInDir(root string, bufWriter *bufio.Writer, ignoreError bool) error {
+func printChunkFilesInDir(root string, bufWriter *bufio.Writer, ignoreError bool, options Options) error {
 	return filepath.Walk(root, func(path string, info fs.FileInfo, walkErr error) error {
 		if walkErr != nil {
 			if ignoreError {
@@ -38,7 +43,7 @@ func printChunkFilesInDir(root string, bufWriter *bufio.Writer, ignoreError bool
 		if info.IsDir() {
 			return nil
 		}
-		if decErr := PrintChunkFileInJSON(path, false, bufWriter); decErr != nil {
+		if decErr := PrintChunkFileInJSONWithOptions(path, false, options, bufWriter); decErr != nil {
 			if ignoreError {
 				logger.Errorf("failed to print %s: %v", path, decErr)
 			} else {
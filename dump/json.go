@@ -11,16 +11,27 @@ import (
 	"strings"
 
 	"github.com/relex/fluentlib/protocol/fluentbitchunk"
+	"github.com/relex/fluentlib/protocol/fluentbitsignal"
 	"github.com/relex/fluentlib/protocol/forwardprotocol"
 	"github.com/relex/fluentlib/util"
 	"github.com/relex/gotils/logger"
 	"github.com/vmihailenco/msgpack/v4"
 )
 
+// Options contains optional checks when dumping files
+type Options struct {
+	VerifyCRC bool // Verify CRC32 of Fluent-bit chunks, if written with storage.checksum on, see fluentbitchunk.VerifyChunkCRC
+}
+
 // PrintChunkFileInJSON dumps all logs in the given file in JSON format. Each log (event) is followed by a newline.
 //
 // The file must be either a Fluent-bit chunk or a Fluentd forward message
 func PrintChunkFileInJSON(path string, indented bool, writer io.Writer) error {
+	return PrintChunkFileInJSONWithOptions(path, indented, Options{VerifyCRC: false}, writer)
+}
+
+// PrintChunkFileInJSONWithOptions is PrintChunkFileInJSON with optional checks
+func PrintChunkFileInJSONWithOptions(path string, indented bool, options Options, writer io.Writer) error {
 	fileData, ioErr := ioutil.ReadFile(path)
 	if ioErr != nil {
 		return fmt.Errorf("error opening %s: %w", path, ioErr)
@@ -28,23 +39,47 @@ func PrintChunkFileInJSON(path string, indented bool, writer io.Writer) error {
 
 	switch strings.ToLower(filepath.Ext(path)) {
 	case ".flb":
-		flbTag, flbPayload, flbErr := fluentbitchunk.ParseChunk(fileData)
+		if options.VerifyCRC {
+			if crcErr := fluentbitchunk.VerifyChunkCRC(fileData); crcErr != nil {
+				return fmt.Errorf("failed to verify fluent-bit chunk file %s: %w", path, crcErr)
+			}
+		}
+		flbHeader, flbPayload, flbErr := fluentbitchunk.ParseChunk(fileData)
 		if flbErr != nil {
 			return fmt.Errorf("failed to parse fluent-bit chunk file %s: %w", path, flbErr)
 		}
-		logger.Infof("parsed fluent-bit chunk file: %s, tag=%s", path, flbTag)
+		switch flbHeader.EventType {
+		case fluentbitchunk.EventTypeLogs:
+			// continue below
+		case fluentbitchunk.EventTypeMetrics, fluentbitchunk.EventTypeTraces:
+			logger.Infof("parsed fluent-bit chunk file: %s, tag=%s, type=%s", path, flbHeader.Tag, flbHeader.EventType)
+			signal := forwardprotocol.FluentSignalMetrics
+			if flbHeader.EventType == fluentbitchunk.EventTypeTraces {
+				signal = forwardprotocol.FluentSignalTraces
+			}
+			if prtError := PrintSignalInJSON(flbHeader.Tag, signal, flbPayload, indented, writer); prtError != nil {
+				return fmt.Errorf("corrupted fluent-bit chunk file %s: %w", path, prtError)
+			}
+			return nil
+		default:
+			logger.Infof("skipped fluent-bit chunk file: %s, tag=%s, type=%s", path, flbHeader.Tag, flbHeader.EventType)
+			return nil
+		}
+		logger.Infof("parsed fluent-bit chunk file: %s, tag=%s, type=%s", path, flbHeader.Tag, flbHeader.EventType)
 		lastI := -1
 		iterError := fluentbitchunk.IterateRecords(flbPayload, func(event forwardprotocol.EventEntry) error {
 			lastI++
-			return PrintEventInJSON(event, flbTag, indented, writer, lastI == 0)
+			return PrintEventInJSON(event, flbHeader.Tag, indented, writer, lastI == 0)
 		})
 		if iterError != nil {
 			return fmt.Errorf("corrupted fluent-bit chunk file %s on the %dth record: %w", path, lastI, iterError)
 		}
 		return nil
 	case ".ff":
-		var message forwardprotocol.Message
-		if decErr := msgpack.NewDecoder(bytes.NewReader(fileData)).Decode(&message); decErr != nil {
+		message, decErr := forwardprotocol.DecodeMessage(msgpack.NewDecoder(bytes.NewReader(fileData)), forwardprotocol.DecodeOptions{
+			LazyEntries: true,
+		})
+		if decErr != nil {
 			return fmt.Errorf("failed to decode forward message file %s: %w", path, decErr)
 		}
 		if prtError := PrintMessageInJSON(message, indented, writer); prtError != nil {
@@ -82,14 +117,63 @@ func PrintChunkFileInJSON(path string, indented bool, writer io.Writer) error {
 }
 
 // PrintMessageInJSON dumps all logs in the given message in JSON format. Each log (event) is followed by a newline.
+//
+// Messages of metrics or traces are printed by PrintSignalInJSON
 func PrintMessageInJSON(message forwardprotocol.Message, indented bool, writer io.Writer) error {
-	for i, event := range message.Entries {
-		if err := PrintEventInJSON(event, message.Tag, indented, writer, i == 0); err != nil {
-			_, _ = writer.Write([]byte("\n]\n")) // ignore error
-			return err
+	if message.Option.FluentSignal != forwardprotocol.FluentSignalLogs {
+		return PrintSignalInJSON(message.Tag, message.Option.FluentSignal, message.Payload, indented, writer)
+	}
+	count := 0
+	err := message.ForEachEntry(func(event forwardprotocol.EventEntry) error {
+		count++
+		return PrintEventInJSON(event, message.Tag, indented, writer, count == 1)
+	})
+	if count > 0 {
+		_, _ = writer.Write([]byte("\n]\n")) // ignore error
+	}
+	return err
+}
+
+// PrintSignalInJSON decodes and dumps metrics or traces in JSON format
+//
+// The output is an array of [tag, context] for each cmetrics or ctraces context in the payload
+func PrintSignalInJSON(tag string, signal forwardprotocol.FluentSignal, payload []byte, indented bool, writer io.Writer) error {
+	var contexts []interface{}
+	switch signal {
+	case forwardprotocol.FluentSignalMetrics:
+		metrics, err := fluentbitsignal.DecodeMetrics(payload)
+		if err != nil {
+			return fmt.Errorf("failed to decode metrics: %w", err)
+		}
+		for _, ctx := range metrics {
+			contexts = append(contexts, ctx)
 		}
+	case forwardprotocol.FluentSignalTraces:
+		traces, err := fluentbitsignal.DecodeTraces(payload)
+		if err != nil {
+			return fmt.Errorf("failed to decode traces: %w", err)
+		}
+		for _, ctx := range traces {
+			contexts = append(contexts, ctx)
+		}
+	default:
+		return fmt.Errorf("unsupported fluent signal: %s", signal)
 	}
-	if len(message.Entries) > 0 {
+
+	for i, ctx := range contexts {
+		separator := ",\n"
+		if i == 0 {
+			separator = "[\n"
+		}
+		jsonBin, jsonErr := marshalJSON([]interface{}{tag, ctx}, indented)
+		if jsonErr != nil {
+			return fmt.Errorf("failed to marshal %s as JSON: %w", signal, jsonErr)
+		}
+		if _, werr := writer.Write(append([]byte(separator), jsonBin...)); werr != nil {
+			return fmt.Errorf("failed to print JSON: %w", werr)
+		}
+	}
+	if len(contexts) > 0 {
 		_, _ = writer.Write([]byte("\n]\n")) // ignore error
 	}
 	return nil
@@ -118,24 +202,28 @@ func PrintEventInJSON(event forwardprotocol.EventEntry, tag string, indented boo
 }
 
 // FormatEventInJSON formats a single record in EventEntry in JSON format.
+//
+// The output is [tag, time, record], or [tag, time, record, metadata] if the event has metadata (Fluent Bit v2 format)
 func FormatEventInJSON(event forwardprotocol.EventEntry, tag string, indented bool) ([]byte, error) {
-	var jsonBin []byte
-	var jsonErr error
-	if indented {
-		jsonBin, jsonErr = json.MarshalIndent([]interface{}{
-			tag,
-			util.TimeToUnixFloat(event.Time.Time),
-			event.Record,
-		}, "", "  ")
-	} else {
-		jsonBin, jsonErr = json.Marshal([]interface{}{
-			tag,
-			util.TimeToUnixFloat(event.Time.Time),
-			event.Record,
-		})
+	fields := []interface{}{
+		tag,
+		util.TimeToUnixFloat(event.Time.Time),
+		event.Record,
 	}
+	if event.Metadata != nil {
+		fields = append(fields, event.Metadata)
+	}
+
+	jsonBin, jsonErr := marshalJSON(fields, indented)
 	if jsonErr != nil {
 		return nil, fmt.Errorf("failed to marshal as JSON: %s: %w", event, jsonErr)
 	}
 	return jsonBin, nil
 }
+
+func marshalJSON(value interface{}, indented bool) ([]byte, error) {
+	if indented {
+		return json.MarshalIndent(value, "", "  ")
+	}
+	return json.Marshal(value)
+}
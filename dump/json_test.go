@@ -2,12 +2,19 @@ package dump
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/relex/fluentlib/protocol/fluentbitchunk"
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
 	"github.com/relex/fluentlib/testdata"
 	"github.com/relex/fluentlib/util"
 	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
 )
 
 func TestGenerateExpectedOutputs(t *testing.T) {
@@ -42,3 +49,77 @@ func TestPrintChunkFilesInJSON(t *testing.T) {
 		assert.Equal(t, string(expected), wrt.String(), fn)
 	}
 }
+
+func TestPrintChunkFileInJSONWithCRC(t *testing.T) {
+	dirPath, dirErr := os.MkdirTemp("", "fluentlib-dump-test-*")
+	assert.Nil(t, dirErr)
+	defer os.RemoveAll(dirPath)
+
+	chunk, err := fluentbitchunk.MakeChunk("foo", []forwardprotocol.EventEntry{
+		{
+			Time:   forwardprotocol.EventTime{Time: time.Date(2022, 1, 14, 10, 30, 55, 0, time.UTC)},
+			Record: map[string]interface{}{"msg": "Hello"},
+		},
+	}, forwardprotocol.EncodeOptions{})
+	assert.Nil(t, err)
+	chunk[len(chunk)-1] ^= 0xFF
+	corruptFile := filepath.Join(dirPath, "corrupt.flb")
+	assert.Nil(t, os.WriteFile(corruptFile, chunk, 0600))
+	copy(chunk[2:6], []byte{0, 0, 0, 0})
+	noCRCFile := filepath.Join(dirPath, "nocrc.flb")
+	assert.Nil(t, os.WriteFile(noCRCFile, chunk, 0600))
+
+	assert.Nil(t, PrintChunkFileInJSON(corruptFile, false, &bytes.Buffer{}))
+	var crcErr *fluentbitchunk.CRCMismatchError
+	err = PrintChunkFileInJSONWithOptions(corruptFile, false, Options{VerifyCRC: true}, &bytes.Buffer{})
+	assert.True(t, errors.As(err, &crcErr), err)
+	assert.Nil(t, PrintChunkFileInJSONWithOptions(noCRCFile, false, Options{VerifyCRC: true}, &bytes.Buffer{}))
+}
+
+func TestFormatEventInJSONWithMetadata(t *testing.T) {
+	event := forwardprotocol.EventEntry{
+		Time:     forwardprotocol.EventTime{Time: time.Date(2022, 1, 14, 10, 30, 55, 0, time.UTC)},
+		Record:   map[string]interface{}{"msg": "Hello"},
+		Metadata: map[string]interface{}{"k": "v"},
+	}
+
+	jsonBin, err := FormatEventInJSON(event, "foo", false)
+	assert.Nil(t, err)
+	assert.Equal(t, `["foo",1642156255,{"msg":"Hello"},{"k":"v"}]`, string(jsonBin))
+
+	event.Metadata = nil
+	jsonBin, err = FormatEventInJSON(event, "foo", false)
+	assert.Nil(t, err)
+	assert.Equal(t, `["foo",1642156255,{"msg":"Hello"}]`, string(jsonBin))
+}
+
+func TestPrintMessageInJSONWithMetrics(t *testing.T) {
+	payload, err := msgpack.Marshal(map[string]interface{}{
+		"meta": map[string]interface{}{},
+		"metrics": []interface{}{
+			map[string]interface{}{
+				"meta": map[string]interface{}{
+					"type":   0,
+					"opts":   map[string]interface{}{"ns": "fluentbit", "ss": "", "name": "records", "desc": "Records"},
+					"labels": []interface{}{"name"},
+				},
+				"values": []interface{}{
+					map[string]interface{}{"ts": 1642156255000000000, "value": 5.0, "labels": []interface{}{"cpu.0"}, "hash": 1},
+				},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	wrt := &bytes.Buffer{}
+	assert.Nil(t, PrintMessageInJSON(forwardprotocol.Message{
+		Tag:     "metrics",
+		Option:  forwardprotocol.TransportOption{FluentSignal: forwardprotocol.FluentSignalMetrics},
+		Payload: payload,
+	}, false, wrt))
+	assert.Equal(t, `[
+["metrics",{"metrics":[{"type":"counter","namespace":"fluentbit","subsystem":"","name":"records","description":"Records","label_keys":["name"],"samples":[{"timestamp":"`+
+		time.Unix(1642156255, 0).Format(time.RFC3339Nano)+`","labels":{"name":"cpu.0"},"value":5}]}]}]
+]
+`, wrt.String())
+}
@@ -3,6 +3,7 @@ module github.com/relex/fluentlib
 go 1.18
 
 require (
+	github.com/iancoleman/strcase v0.2.0
 	github.com/relex/gotils v0.0.0-20220711120455-cc7360463721
 	github.com/stretchr/testify v1.7.2
 	github.com/vmihailenco/msgpack/v4 v4.3.12
@@ -16,7 +17,6 @@ require (
 	github.com/fsnotify/fsnotify v1.5.4 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
-	github.com/iancoleman/strcase v0.2.0 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/magiconair/properties v1.8.6 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
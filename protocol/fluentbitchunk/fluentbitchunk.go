@@ -3,8 +3,10 @@ package fluentbitchunk
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 
 	"github.com/relex/fluentlib/protocol/forwardprotocol"
@@ -15,60 +17,210 @@ import (
  *    +--------------+----------------+
  *    |     0xC1     |     0x00       + <<<<<<<<<<<<<<<<<<< Ident1, Ident2
  *    +--------------+----------------+
- *    |       4 BYTES CRC32           | <<<<<<<<<<<<<<<<<<< CRC of everything after padding
+ *    |       4 BYTES CRC32           | <<<<<<<<<<<<<<<<<<< CRC of everything after padding (big endian)
  *    |      16 BYTES Padding         |
- *    +-------------------------------| <<<<<<<<<<<<<<<<<<< TagLengthStart
- *    |          Tag length           |
+ *    +-------------------------------| <<<<<<<<<<<<<<<<<<< MetadataLengthStart
+ *    |        Metadata length        |
  *    |         (big endian)          |
- *    +-------------------------------+ <<<<<<<<<<<<<<<<<<< TagValueStart
+ *    +-------------------------------+ <<<<<<<<<<<<<<<<<<< MetadataStart
+ *    |     0xF1     |     0x77       | <<<<<<<<<<<<<<<<<<< Magic1, Magic2 (absent in older versions)
+ *    +--------------+----------------+
+ *    |  Event type  |    Padding     | <<<<<<<<<<<<<<<<<<< EventType (absent in older versions)
+ *    +--------------+----------------+
  *    |                               |
- *    |          Tag value            | length = tag length
+ *    |          Tag value            | length = metadata length - 4 (or metadata length in older versions)
  *    |                               |
  *    +-------------------------------+
  *    |                               |
- *    |   sequence of msgpack arrays  |
+ *    |   sequence of msgpack arrays  | or msgpack payload of metrics / traces
  *    |                               |
  *    +-------------------------------+
  */
 
 const (
-	ident1         = 0xC1
-	ident2         = 0x00
-	tagLengthStart = 22
-	tagValueStart  = 24
+	ident1              = 0xC1
+	ident2              = 0x00
+	crcStart            = 2
+	metadataLengthStart = 22
+	metadataStart       = 24
+	maxMetadataLength   = 65535
+	magic1              = 0xF1
+	magic2              = 0x77
+	magicHeaderLength   = 4
+)
+
+// EventType is the type of events stored in a chunk
+type EventType byte
+
+const (
+	// EventTypeLogs means the chunk payload is a sequence of log events
+	EventTypeLogs EventType = 0
+
+	// EventTypeMetrics means the chunk payload is cmetrics in msgpack
+	EventTypeMetrics EventType = 1
+
+	// EventTypeTraces means the chunk payload is ctraces in msgpack
+	EventTypeTraces EventType = 2
 )
 
-// MakeHeader creates a file header without CRC for testing
+func (t EventType) String() string {
+	switch t {
+	case EventTypeLogs:
+		return "logs"
+	case EventTypeMetrics:
+		return "metrics"
+	case EventTypeTraces:
+		return "traces"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(t))
+	}
+}
+
+// ChunkHeader is the parsed header and metadata block of a chunk
+type ChunkHeader struct {
+	EventType EventType // Type of events in payload, always EventTypeLogs for legacy chunks
+	Tag       string    // Tag of all events
+	Metadata  []byte    // Raw metadata block including magic bytes and tag, not used in creation
+	CRC       uint32    // CRC32 stored in header, not used in creation
+	Legacy    bool      // Whether the metadata is tag only, without magic bytes and event type
+}
+
+// CRCMismatchError is returned by VerifyChunkCRC if the CRC32 in header doesn't match the content
+type CRCMismatchError struct {
+	Expected uint32 // CRC32 stored in header
+	Actual   uint32 // CRC32 computed from content
+}
+
+func (e *CRCMismatchError) Error() string {
+	return fmt.Sprintf(".flb chunk CRC mismatch: header=%08x, content=%08x", e.Expected, e.Actual)
+}
+
+// MakeHeader creates a legacy file header without CRC for testing
+//
+// Use MakeChunk to create a complete chunk with CRC
 func MakeHeader(tag string) []byte {
 	btag := []byte(tag)
-	if len(btag) > 65535 {
+	if len(btag) > maxMetadataLength {
 		panic(fmt.Sprintf("input tag is too long: len=%d", len(btag)))
 	}
-	header := make([]byte, tagValueStart+len(btag))
+	header := make([]byte, metadataStart+len(btag))
 	header[0] = ident1
 	header[1] = ident2
-	header[tagLengthStart] = byte(len(btag) >> 8)
-	header[tagLengthStart+1] = byte(len(btag) & 0xFF)
-	copy(header[tagValueStart:], btag)
+	binary.BigEndian.PutUint16(header[metadataLengthStart:], uint16(len(btag)))
+	copy(header[metadataStart:], btag)
 	return header
 }
 
+// MakeChunk creates the .flb file content of logs with valid CRC, from the given tag and events
+//
+// Events are encoded as msgpack arrays by the given options, in which Mode is ignored
+func MakeChunk(tag string, entries []forwardprotocol.EventEntry, options forwardprotocol.EncodeOptions) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	encoder := msgpack.NewEncoder(buffer)
+	for i, entry := range entries {
+		if err := forwardprotocol.EncodeEventEntry(encoder, entry, options); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+	}
+	return MakeChunkWithHeader(ChunkHeader{
+		EventType: EventTypeLogs,
+		Tag:       tag,
+		Metadata:  nil,
+		CRC:       0,
+		Legacy:    false,
+	}, buffer.Bytes())
+}
+
+// MakeChunkWithHeader creates the .flb file content with valid CRC, from the given header and raw payload
+//
+// The metadata block is built from EventType, Tag and Legacy of the header
+func MakeChunkWithHeader(header ChunkHeader, payload []byte) ([]byte, error) {
+	var metadata []byte
+	if header.Legacy {
+		if header.EventType != EventTypeLogs {
+			return nil, fmt.Errorf("legacy chunk cannot contain %s", header.EventType)
+		}
+		metadata = []byte(header.Tag)
+	} else {
+		metadata = append([]byte{magic1, magic2, byte(header.EventType), 0}, header.Tag...)
+	}
+	if len(metadata) > maxMetadataLength {
+		return nil, fmt.Errorf("input tag is too long: len=%d", len(header.Tag))
+	}
+
+	chunk := make([]byte, metadataStart, metadataStart+len(metadata)+len(payload))
+	chunk[0] = ident1
+	chunk[1] = ident2
+	binary.BigEndian.PutUint16(chunk[metadataLengthStart:], uint16(len(metadata)))
+	chunk = append(chunk, metadata...)
+	chunk = append(chunk, payload...)
+	binary.BigEndian.PutUint32(chunk[crcStart:], computeCRC(chunk))
+	return chunk, nil
+}
+
 // ParseChunk parses the .flb file content or returns error
-// Returns (tag, payload, error)
-func ParseChunk(data []byte) (string, []byte, error) {
-	if len(data) < tagValueStart {
-		return "", nil, errors.New(".flb chunk is too small")
+//
+// The CRC in header is not verified. Use VerifyChunkCRC to check it.
+//
+// Returns (header, payload, error)
+func ParseChunk(data []byte) (ChunkHeader, []byte, error) {
+	header := ChunkHeader{}
+	if len(data) < metadataStart {
+		return header, nil, errors.New(".flb chunk is too small")
 	}
 	if data[0] != ident1 || data[1] != ident2 {
-		return "", nil, errors.New(".flb chunk header is incorrect")
+		return header, nil, errors.New(".flb chunk header is incorrect")
 	}
-	tagLen := (uint16(data[tagLengthStart]) << 8) | uint16(data[tagLengthStart+1])
-	tag := string(data[tagValueStart : tagValueStart+tagLen])
-	payload := data[tagValueStart+tagLen:]
-	return tag, payload, nil
+	metadataLen := int(binary.BigEndian.Uint16(data[metadataLengthStart:]))
+	if len(data) < metadataStart+metadataLen {
+		return header, nil, fmt.Errorf(".flb chunk is too small for metadata: len=%d", metadataLen)
+	}
+	metadata := data[metadataStart : metadataStart+metadataLen]
+	payload := data[metadataStart+metadataLen:]
+
+	header.Metadata = metadata
+	header.CRC = binary.BigEndian.Uint32(data[crcStart:])
+	if len(metadata) >= magicHeaderLength && metadata[0] == magic1 && metadata[1] == magic2 {
+		header.EventType = EventType(metadata[2])
+		header.Tag = string(metadata[magicHeaderLength:])
+	} else {
+		header.EventType = EventTypeLogs
+		header.Tag = string(metadata)
+		header.Legacy = true
+	}
+	return header, payload, nil
+}
+
+// VerifyChunkCRC verifies the CRC32 in header of the .flb file content, and returns *CRCMismatchError if it doesn't
+// match
+//
+// Fluent Bit only writes CRC if storage.checksum is enabled, which is off by default. Zero CRC is treated as absent and
+// not verified.
+func VerifyChunkCRC(data []byte) error {
+	if len(data) < metadataStart {
+		return errors.New(".flb chunk is too small")
+	}
+	expected := binary.BigEndian.Uint32(data[crcStart:])
+	if expected == 0 {
+		return nil
+	}
+	if actual := computeCRC(data); actual != expected {
+		return &CRCMismatchError{
+			Expected: expected,
+			Actual:   actual,
+		}
+	}
+	return nil
+}
+
+// computeCRC computes CRC32 of everything after padding, the same as chunkio
+func computeCRC(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data[metadataLengthStart:])
 }
 
 // IterateRecords iterates through all records in fluent-bit chunk payload
+//
+// The payload must be of EventTypeLogs
 func IterateRecords(payload []byte, callback func(event forwardprotocol.EventEntry) error) error {
 	buffer := bytes.NewBuffer(payload) // the real underlying reader of data, as bytes.Buffer
 	decoder := msgpack.NewDecoder(buffer)
@@ -0,0 +1,116 @@
+package fluentbitchunk
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
+	"github.com/stretchr/testify/assert"
+)
+
+var chunkEntries = []forwardprotocol.EventEntry{
+	{
+		Time:   forwardprotocol.EventTime{Time: time.Date(2022, 1, 14, 10, 30, 55, 123, time.UTC)},
+		Record: map[string]interface{}{"msg": "Hello", "level": "info"},
+	},
+	{
+		Time:     forwardprotocol.EventTime{Time: time.Date(2022, 1, 14, 10, 30, 56, 456, time.UTC)},
+		Record:   map[string]interface{}{"msg": "World", "level": "warn"},
+		Metadata: map[string]interface{}{"k": "v"},
+	},
+}
+
+func TestMakeAndParseChunk(t *testing.T) {
+	for _, v2 := range []bool{false, true} {
+		chunk, err := MakeChunk("my-app", chunkEntries, forwardprotocol.EncodeOptions{EventFormatV2: v2})
+		assert.Nil(t, err, "v2=%t", v2)
+
+		assert.Nil(t, VerifyChunkCRC(chunk), "v2=%t", v2)
+		header, payload, err := ParseChunk(chunk)
+		assert.Nil(t, err, "v2=%t", v2)
+		assert.NotZero(t, header.CRC, "v2=%t", v2)
+		assert.Equal(t, "my-app", header.Tag, "v2=%t", v2)
+		assert.Equal(t, EventTypeLogs, header.EventType, "v2=%t", v2)
+		assert.False(t, header.Legacy, "v2=%t", v2)
+
+		var decoded []forwardprotocol.EventEntry
+		assert.Nil(t, IterateRecords(payload, func(event forwardprotocol.EventEntry) error {
+			decoded = append(decoded, event)
+			return nil
+		}), "v2=%t", v2)
+		if assert.Len(t, decoded, len(chunkEntries), "v2=%t", v2) {
+			for i, event := range decoded {
+				assert.True(t, chunkEntries[i].Time.Equal(event.Time.Time), "v2=%t records[%d]", v2, i)
+				assert.Equal(t, chunkEntries[i].Record, event.Record, "v2=%t records[%d]", v2, i)
+			}
+			if v2 {
+				assert.Equal(t, map[string]interface{}{}, decoded[0].Metadata)
+				assert.Equal(t, chunkEntries[1].Metadata, decoded[1].Metadata)
+			} else {
+				assert.Nil(t, decoded[1].Metadata)
+			}
+		}
+	}
+}
+
+func TestParseChunkCRCMismatch(t *testing.T) {
+	chunk, err := MakeChunk("my-app", chunkEntries, forwardprotocol.EncodeOptions{})
+	assert.Nil(t, err)
+	chunk[len(chunk)-1] ^= 0xFF
+
+	header, _, err := ParseChunk(chunk)
+	assert.Nil(t, err)
+	assert.Equal(t, "my-app", header.Tag)
+
+	err = VerifyChunkCRC(chunk)
+	var crcErr *CRCMismatchError
+	if assert.True(t, errors.As(err, &crcErr), err) {
+		assert.Equal(t, header.CRC, crcErr.Expected)
+		assert.NotEqual(t, crcErr.Expected, crcErr.Actual)
+	}
+
+	// zero CRC is written by Fluent Bit without storage.checksum, and it's not verified
+	copy(chunk[crcStart:], []byte{0, 0, 0, 0})
+	assert.Nil(t, VerifyChunkCRC(chunk))
+	assert.Nil(t, VerifyChunkCRC(MakeHeader("old")))
+
+	_, _, err = ParseChunk(chunk[:metadataStart+2])
+	assert.EqualError(t, err, ".flb chunk is too small for metadata: len=10")
+	assert.EqualError(t, VerifyChunkCRC(chunk[:metadataStart-1]), ".flb chunk is too small")
+}
+
+func TestParseChunkHeader(t *testing.T) {
+	type test struct {
+		header   ChunkHeader
+		metadata []byte
+	}
+
+	testList := []test{
+		{ChunkHeader{EventType: EventTypeLogs, Tag: "app.logs"}, []byte("\xF1\x77\x00\x00app.logs")},
+		{ChunkHeader{EventType: EventTypeMetrics, Tag: "app.metrics"}, []byte("\xF1\x77\x01\x00app.metrics")},
+		{ChunkHeader{EventType: EventTypeTraces, Tag: "app.traces"}, []byte("\xF1\x77\x02\x00app.traces")},
+		{ChunkHeader{EventType: EventTypeLogs, Tag: "app.legacy", Legacy: true}, []byte("app.legacy")},
+	}
+
+	for i, test := range testList {
+		chunk, err := MakeChunkWithHeader(test.header, []byte("payload"))
+		assert.Nil(t, err, "test[%d]", i)
+
+		assert.Nil(t, VerifyChunkCRC(chunk), "test[%d]", i)
+		header, payload, err := ParseChunk(chunk)
+		assert.Nil(t, err, "test[%d]", i)
+		assert.Equal(t, test.header.EventType, header.EventType, "test[%d]", i)
+		assert.Equal(t, test.header.Tag, header.Tag, "test[%d]", i)
+		assert.Equal(t, test.header.Legacy, header.Legacy, "test[%d]", i)
+		assert.Equal(t, test.metadata, header.Metadata, "test[%d]", i)
+		assert.Equal(t, "payload", string(payload), "test[%d]", i)
+	}
+
+	legacyHeader, _, err := ParseChunk(MakeHeader("old"))
+	assert.Nil(t, err)
+	assert.Equal(t, ChunkHeader{EventType: EventTypeLogs, Tag: "old", Metadata: []byte("old"), CRC: 0, Legacy: true}, legacyHeader)
+
+	_, err = MakeChunkWithHeader(ChunkHeader{EventType: EventTypeMetrics, Tag: "old", Legacy: true}, nil)
+	assert.EqualError(t, err, "legacy chunk cannot contain metrics")
+}
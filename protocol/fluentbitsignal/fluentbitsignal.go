@@ -0,0 +1,35 @@
+// Package fluentbitsignal provides decoding of Fluent Bit metrics (cmetrics) and traces (ctraces) in msgpack format
+//
+// The payloads come from forward messages with "fluent_signal" option or chunks of non-log event types. Each payload
+// may contain one or more contexts serialized one after another.
+//
+// Decoding is tolerant: unknown keys are ignored and missing keys result in zero values.
+package fluentbitsignal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// iterateContexts decodes each top-level msgpack value in payload as generic value and passes it to callback
+func iterateContexts(payload []byte, callback func(value interface{}) error) error {
+	reader := bytes.NewReader(payload)
+	decoder := msgpack.NewDecoder(reader)
+	for index := 0; ; index++ {
+		startPos := len(payload) - reader.Len()
+		value, err := decoder.DecodeInterface()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("context %d at %d/%d: %w", index, startPos, len(payload), err)
+		}
+		if err := callback(value); err != nil {
+			return fmt.Errorf("context %d at %d/%d: %w", index, startPos, len(payload), err)
+		}
+	}
+}
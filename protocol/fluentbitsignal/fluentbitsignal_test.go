@@ -0,0 +1,188 @@
+package fluentbitsignal
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/relex/fluentlib/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func makeMetricFamily(metricType MetricType, name string, labelKeys []interface{}, extraMeta map[string]interface{}, values ...map[string]interface{}) map[string]interface{} {
+	meta := map[string]interface{}{
+		"ver":  2,
+		"type": int(metricType),
+		"opts": map[string]interface{}{
+			"ns":   "fluentbit",
+			"ss":   "input",
+			"name": name,
+			"desc": "Test " + name,
+		},
+		"labels": labelKeys,
+	}
+	for key, value := range extraMeta {
+		meta[key] = value
+	}
+	valueList := make([]interface{}, len(values))
+	for i, v := range values {
+		valueList[i] = v
+	}
+	return map[string]interface{}{
+		"meta":   meta,
+		"values": valueList,
+	}
+}
+
+func TestDecodeMetrics(t *testing.T) {
+	ts := uint64(time.Date(2023, 5, 1, 12, 0, 0, 500, time.UTC).UnixNano())
+	context := map[string]interface{}{
+		"meta": map[string]interface{}{
+			"cmetrics": map[string]interface{}{},
+			"external": map[string]interface{}{"source": "test"},
+			"processing": map[string]interface{}{
+				"static_labels": []interface{}{[]interface{}{"host", "node1"}},
+			},
+		},
+		"metrics": []interface{}{
+			makeMetricFamily(MetricTypeCounter, "records_total", []interface{}{"name"}, nil,
+				map[string]interface{}{"ts": ts, "value": 42.0, "labels": []interface{}{"cpu.0"}, "hash": uint64(math.MaxUint64)},
+			),
+			makeMetricFamily(MetricTypeGauge, "uptime", []interface{}{}, nil,
+				map[string]interface{}{"ts": ts, "value": 1, "hash": 0},
+			),
+			makeMetricFamily(MetricTypeHistogram, "latency", []interface{}{}, map[string]interface{}{"buckets": []interface{}{0.1, 1.0}},
+				map[string]interface{}{"ts": ts, "histogram": map[string]interface{}{"buckets": []interface{}{1, 3, 4}, "sum": 2.5, "count": 4}},
+			),
+			makeMetricFamily(MetricTypeSummary, "size", []interface{}{}, map[string]interface{}{"quantiles": []interface{}{0.5, 0.9}},
+				map[string]interface{}{"ts": ts, "summary": map[string]interface{}{
+					"quantiles_set": 1,
+					"quantiles":     []interface{}{math.Float64bits(10), math.Float64bits(20.5)},
+					"count":         7,
+					"sum":           math.Float64bits(99.5),
+				}},
+			),
+		},
+	}
+	single, err := msgpack.Marshal(context)
+	assert.Nil(t, err)
+	payload := append(append([]byte{}, single...), single...)
+
+	contexts, err := DecodeMetrics(payload)
+	assert.Nil(t, err)
+	if !assert.Len(t, contexts, 2) {
+		return
+	}
+	ctx := contexts[0]
+	assert.Equal(t, map[string]string{"host": "node1"}, ctx.StaticLabels)
+	assert.Equal(t, map[string]interface{}{"source": "test"}, ctx.Metadata)
+	if !assert.Len(t, ctx.Families, 4) {
+		return
+	}
+
+	counter := ctx.Families[0]
+	assert.Equal(t, MetricTypeCounter, counter.Type)
+	assert.Equal(t, "fluentbit_input_records_total", counter.FullName())
+	assert.Equal(t, "Test records_total", counter.Description)
+	assert.Equal(t, []string{"name"}, counter.LabelKeys)
+	if assert.Len(t, counter.Samples, 1) {
+		assert.Equal(t, int64(ts), counter.Samples[0].Timestamp.UnixNano())
+		assert.Equal(t, 42.0, counter.Samples[0].Value)
+		assert.Equal(t, map[string]string{"name": "cpu.0"}, counter.Samples[0].Labels)
+	}
+
+	gauge := ctx.Families[1]
+	if assert.Len(t, gauge.Samples, 1) {
+		assert.Equal(t, 1.0, gauge.Samples[0].Value)
+		assert.Nil(t, gauge.Samples[0].Labels)
+	}
+
+	histogram := ctx.Families[2]
+	assert.Equal(t, []float64{0.1, 1.0}, histogram.Buckets)
+	if assert.Len(t, histogram.Samples, 1) {
+		assert.Equal(t, &HistogramValue{BucketCounts: []uint64{1, 3, 4}, Sum: 2.5, Count: 4}, histogram.Samples[0].Histogram)
+	}
+
+	summary := ctx.Families[3]
+	assert.Equal(t, []float64{0.5, 0.9}, summary.Quantiles)
+	if assert.Len(t, summary.Samples, 1) {
+		assert.Equal(t, &SummaryValue{QuantileValues: []float64{10, 20.5}, Sum: 99.5, Count: 7}, summary.Samples[0].Summary)
+	}
+
+	_, err = DecodeMetrics(append(single, 0xC1))
+	assert.Error(t, err)
+	_, err = DecodeMetrics([]byte{0x01})
+	assert.EqualError(t, err, "context 0 at 0/1: invalid metrics context: int8")
+}
+
+func TestDecodeTraces(t *testing.T) {
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	context := map[string]interface{}{
+		"meta": map[string]interface{}{},
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes":               map[string]interface{}{"service.name": "shop"},
+					"dropped_attributes_count": 0,
+				},
+				"schema_url": "https://opentelemetry.io/schemas/1.4.0",
+				"scope_spans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "lib", "version": "1.0"},
+						"spans": []interface{}{
+							map[string]interface{}{
+								"trace_id":             []byte{0x01, 0x02, 0xab},
+								"span_id":              []byte{0xff},
+								"parent_span_id":       nil,
+								"name":                 "checkout",
+								"kind":                 2,
+								"start_time_unix_nano": uint64(start.UnixNano()),
+								"end_time_unix_nano":   uint64(start.Add(time.Second).UnixNano()),
+								"attributes":           map[string]interface{}{"http": map[string]interface{}{"code": 200}},
+								"events": []interface{}{
+									map[string]interface{}{"time_unix_nano": uint64(start.UnixNano()), "name": "paid"},
+								},
+								"links":  []interface{}{},
+								"status": map[string]interface{}{"code": 2, "message": "oops"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	payload, err := msgpack.Marshal(context)
+	assert.Nil(t, err)
+
+	contexts, err := DecodeTraces(payload)
+	assert.Nil(t, err)
+	if !assert.Len(t, contexts, 1) || !assert.Len(t, contexts[0].ResourceSpans, 1) {
+		return
+	}
+	rs := contexts[0].ResourceSpans[0]
+	assert.Equal(t, map[string]interface{}{"service.name": "shop"}, rs.Resource.Attributes)
+	assert.Equal(t, "https://opentelemetry.io/schemas/1.4.0", rs.SchemaURL)
+	if !assert.Len(t, rs.ScopeSpans, 1) || !assert.Len(t, rs.ScopeSpans[0].Spans, 1) {
+		return
+	}
+	assert.Equal(t, Scope{Name: "lib", Version: "1.0"}, rs.ScopeSpans[0].Scope)
+	span := rs.ScopeSpans[0].Spans[0]
+	assert.Equal(t, "0102ab", span.TraceID)
+	assert.Equal(t, "ff", span.SpanID)
+	assert.Equal(t, "", span.ParentSpanID)
+	assert.Equal(t, "checkout", span.Name)
+	assert.Equal(t, SpanKindServer, span.Kind)
+	assert.True(t, start.Equal(span.StartTime))
+	assert.Equal(t, time.Second, span.EndTime.Sub(span.StartTime))
+	if http, isMap := span.Attributes["http"].(map[string]interface{}); assert.True(t, isMap) {
+		code, _ := util.ToInt64(http["code"])
+		assert.Equal(t, int64(200), code)
+	}
+	assert.Equal(t, []SpanEvent{{Time: span.StartTime, Name: "paid"}}, span.Events)
+	assert.Nil(t, span.Links)
+	assert.Equal(t, SpanStatus{Code: 2, Message: "oops"}, span.Status)
+
+	_, err = DecodeTraces([]byte{0x80})
+	assert.EqualError(t, err, "context 0 at 0/1: invalid traces context: map[string]interface {}")
+}
@@ -0,0 +1,252 @@
+package fluentbitsignal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// MetricType is the type of a metric family in cmetrics
+type MetricType int
+
+const (
+	// MetricTypeCounter is a monotonic counter
+	MetricTypeCounter MetricType = 0
+
+	// MetricTypeGauge is a gauge
+	MetricTypeGauge MetricType = 1
+
+	// MetricTypeHistogram is a histogram with buckets, sum and count
+	MetricTypeHistogram MetricType = 2
+
+	// MetricTypeSummary is a summary with quantiles, sum and count
+	MetricTypeSummary MetricType = 3
+
+	// MetricTypeUntyped is a metric without type, treated like gauge
+	MetricTypeUntyped MetricType = 4
+)
+
+func (t MetricType) String() string {
+	switch t {
+	case MetricTypeCounter:
+		return "counter"
+	case MetricTypeGauge:
+		return "gauge"
+	case MetricTypeHistogram:
+		return "histogram"
+	case MetricTypeSummary:
+		return "summary"
+	case MetricTypeUntyped:
+		return "untyped"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// MarshalJSON formats MetricType as its name
+func (t MetricType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// MetricsContext is a cmetrics context, a set of metric families serialized together
+type MetricsContext struct {
+	StaticLabels map[string]string      `json:"static_labels,omitempty"` // Labels added to all metrics, e.g. by processors
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`      // External metadata of context, e.g. from OpenTelemetry input
+	Families     []MetricFamily         `json:"metrics"`
+}
+
+// MetricFamily is a named metric with a fixed set of label keys, containing samples of different label values
+type MetricFamily struct {
+	Type        MetricType `json:"type"`
+	Namespace   string     `json:"namespace"`
+	Subsystem   string     `json:"subsystem"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	LabelKeys   []string   `json:"label_keys"`
+	Buckets     []float64  `json:"buckets,omitempty"`   // Upper bounds of buckets for histogram, excluding +Inf
+	Quantiles   []float64  `json:"quantiles,omitempty"` // Quantiles for summary, e.g. 0.5, 0.99
+	Samples     []Sample   `json:"samples"`
+}
+
+// Sample is a value of metric family under specific label values
+type Sample struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Labels      map[string]string `json:"labels"`              // Label values by keys, nil if the metric has no labels
+	Value       float64           `json:"value"`               // Value of counter, gauge or untyped metric
+	Histogram   *HistogramValue   `json:"histogram,omitempty"` // Value of histogram metric
+	Summary     *SummaryValue     `json:"summary,omitempty"`   // Value of summary metric
+	LabelValues []string          `json:"-"`                   // Label values in the order of MetricFamily.LabelKeys
+}
+
+// HistogramValue is the value of a histogram sample
+type HistogramValue struct {
+	BucketCounts []uint64 `json:"bucket_counts"` // Cumulative counts of buckets, with the last one for +Inf
+	Sum          float64  `json:"sum"`
+	Count        uint64   `json:"count"`
+}
+
+// SummaryValue is the value of a summary sample
+type SummaryValue struct {
+	QuantileValues []float64 `json:"quantile_values"` // Values of MetricFamily.Quantiles, nil if not set
+	Sum            float64   `json:"sum"`
+	Count          uint64    `json:"count"`
+}
+
+// FullName returns the full metric name in Prometheus style, as namespace_subsystem_name without empty parts
+func (f MetricFamily) FullName() string {
+	fullName := ""
+	for _, part := range []string{f.Namespace, f.Subsystem, f.Name} {
+		if part == "" {
+			continue
+		}
+		if fullName != "" {
+			fullName += "_"
+		}
+		fullName += part
+	}
+	return fullName
+}
+
+// DecodeMetrics decodes all cmetrics contexts in the payload
+//
+// Older versions of cmetrics serialize a context as a plain array of metric families, which is also accepted.
+func DecodeMetrics(payload []byte) ([]MetricsContext, error) {
+	var contexts []MetricsContext
+	err := iterateContexts(payload, func(value interface{}) error {
+		context, err := decodeMetricsContext(value)
+		if err != nil {
+			return err
+		}
+		contexts = append(contexts, context)
+		return nil
+	})
+	return contexts, err
+}
+
+func decodeMetricsContext(value interface{}) (MetricsContext, error) {
+	context := MetricsContext{}
+	var families []interface{}
+	if list, isList := value.([]interface{}); isList {
+		families = list
+	} else {
+		root := toObject(value)
+		if root == nil || !root.has("metrics") {
+			return context, fmt.Errorf("invalid metrics context: %T", value)
+		}
+		meta := root.obj("meta")
+		context.Metadata = meta.attributes("external")
+		context.StaticLabels = decodeStaticLabels(meta.obj("processing").list("static_labels"))
+		families = root.list("metrics")
+	}
+	context.Families = make([]MetricFamily, 0, len(families))
+	for i, familyValue := range families {
+		familyObj := toObject(familyValue)
+		if familyObj == nil {
+			return context, fmt.Errorf("metric %d: invalid type %T", i, familyValue)
+		}
+		family := decodeMetricFamily(familyObj)
+		if context.StaticLabels == nil {
+			// older versions keep static labels in each family
+			context.StaticLabels = decodeStaticLabels(familyObj.obj("meta").list("static_labels"))
+		}
+		context.Families = append(context.Families, family)
+	}
+	return context, nil
+}
+
+// decodeStaticLabels decodes static labels from [[key, value], ...]
+func decodeStaticLabels(list []interface{}) map[string]string {
+	if len(list) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(list))
+	for _, pairValue := range list {
+		pair, _ := pairValue.([]interface{})
+		if len(pair) != 2 {
+			continue
+		}
+		kv := toStringList(pair)
+		labels[kv[0]] = kv[1]
+	}
+	return labels
+}
+
+func decodeMetricFamily(familyObj object) MetricFamily {
+	meta := familyObj.obj("meta")
+	opts := meta.obj("opts")
+	family := MetricFamily{
+		Type:        MetricType(meta.uint("type")),
+		Namespace:   opts.str("ns"),
+		Subsystem:   opts.str("ss", "subsystem"),
+		Name:        opts.str("name"),
+		Description: opts.str("desc", "description"),
+		LabelKeys:   toStringList(meta.list("labels")),
+		Buckets:     nil,
+		Quantiles:   nil,
+		Samples:     nil,
+	}
+	switch family.Type {
+	case MetricTypeHistogram:
+		family.Buckets = toFloatList(meta.list("buckets"))
+	case MetricTypeSummary:
+		family.Quantiles = toFloatList(meta.list("quantiles"))
+	}
+	values := familyObj.list("values")
+	family.Samples = make([]Sample, 0, len(values))
+	for _, sampleValue := range values {
+		sampleObj := toObject(sampleValue)
+		if sampleObj == nil {
+			continue
+		}
+		family.Samples = append(family.Samples, decodeSample(family, sampleObj))
+	}
+	return family
+}
+
+func decodeSample(family MetricFamily, sampleObj object) Sample {
+	sample := Sample{
+		Timestamp:   time.Unix(0, int64(sampleObj.uint("ts"))),
+		Labels:      nil,
+		Value:       0,
+		Histogram:   nil,
+		Summary:     nil,
+		LabelValues: toStringList(sampleObj.list("labels")),
+	}
+	if len(sample.LabelValues) > 0 {
+		sample.Labels = make(map[string]string, len(sample.LabelValues))
+		for i, labelValue := range sample.LabelValues {
+			if i < len(family.LabelKeys) {
+				sample.Labels[family.LabelKeys[i]] = labelValue
+			}
+		}
+	}
+	switch {
+	case sampleObj.has("histogram"):
+		hist := sampleObj.obj("histogram")
+		sample.Histogram = &HistogramValue{
+			BucketCounts: toUintList(hist.list("buckets")),
+			Sum:          hist.float("sum"),
+			Count:        hist.uint("count"),
+		}
+	case sampleObj.has("summary"):
+		summary := sampleObj.obj("summary")
+		// quantile values and sum are float64 stored as bits in uint64 by cmetrics
+		value := &SummaryValue{
+			QuantileValues: nil,
+			Sum:            math.Float64frombits(summary.uint("sum")),
+			Count:          summary.uint("count"),
+		}
+		if summary.uint("quantiles_set") != 0 {
+			bits := toUintList(summary.list("quantiles"))
+			value.QuantileValues = make([]float64, len(bits))
+			for i, b := range bits {
+				value.QuantileValues[i] = math.Float64frombits(b)
+			}
+		}
+		sample.Summary = value
+	default:
+		sample.Value = sampleObj.float("value")
+	}
+	return sample
+}
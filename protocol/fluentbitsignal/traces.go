@@ -0,0 +1,242 @@
+package fluentbitsignal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SpanKind is the kind of span, same as in OpenTelemetry
+type SpanKind int
+
+const (
+	// SpanKindUnspecified means the kind isn't set
+	SpanKindUnspecified SpanKind = 0
+
+	// SpanKindInternal is an internal operation
+	SpanKindInternal SpanKind = 1
+
+	// SpanKindServer handles a remote request
+	SpanKindServer SpanKind = 2
+
+	// SpanKindClient sends a remote request
+	SpanKindClient SpanKind = 3
+
+	// SpanKindProducer sends an asynchronous message
+	SpanKindProducer SpanKind = 4
+
+	// SpanKindConsumer receives an asynchronous message
+	SpanKindConsumer SpanKind = 5
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindUnspecified:
+		return "unspecified"
+	case SpanKindInternal:
+		return "internal"
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	case SpanKindProducer:
+		return "producer"
+	case SpanKindConsumer:
+		return "consumer"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// MarshalJSON formats SpanKind as its name
+func (k SpanKind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+// TracesContext is a ctraces context, a set of spans grouped by resources and instrumentation scopes
+type TracesContext struct {
+	ResourceSpans []ResourceSpans `json:"resource_spans"`
+}
+
+// ResourceSpans is a group of spans from the same resource, e.g. a service
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	SchemaURL  string       `json:"schema_url,omitempty"`
+	ScopeSpans []ScopeSpans `json:"scope_spans"`
+}
+
+// Resource is the entity producing spans
+type Resource struct {
+	Attributes             map[string]interface{} `json:"attributes,omitempty"`
+	DroppedAttributesCount uint64                 `json:"dropped_attributes_count,omitempty"`
+}
+
+// ScopeSpans is a group of spans from the same instrumentation scope, e.g. a library
+type ScopeSpans struct {
+	Scope     Scope  `json:"scope"`
+	SchemaURL string `json:"schema_url,omitempty"`
+	Spans     []Span `json:"spans"`
+}
+
+// Scope is the instrumentation scope producing spans
+type Scope struct {
+	Name                   string                 `json:"name,omitempty"`
+	Version                string                 `json:"version,omitempty"`
+	Attributes             map[string]interface{} `json:"attributes,omitempty"`
+	DroppedAttributesCount uint64                 `json:"dropped_attributes_count,omitempty"`
+}
+
+// Span is a single operation in a trace
+//
+// IDs are in lowercase hex, empty if absent
+type Span struct {
+	TraceID                string                 `json:"trace_id"`
+	SpanID                 string                 `json:"span_id"`
+	ParentSpanID           string                 `json:"parent_span_id,omitempty"`
+	TraceState             string                 `json:"trace_state,omitempty"`
+	Name                   string                 `json:"name"`
+	Kind                   SpanKind               `json:"kind"`
+	StartTime              time.Time              `json:"start_time"`
+	EndTime                time.Time              `json:"end_time"`
+	Attributes             map[string]interface{} `json:"attributes,omitempty"`
+	DroppedAttributesCount uint64                 `json:"dropped_attributes_count,omitempty"`
+	Events                 []SpanEvent            `json:"events,omitempty"`
+	DroppedEventsCount     uint64                 `json:"dropped_events_count,omitempty"`
+	Links                  []SpanLink             `json:"links,omitempty"`
+	DroppedLinksCount      uint64                 `json:"dropped_links_count,omitempty"`
+	Status                 SpanStatus             `json:"status"`
+}
+
+// SpanEvent is an event happening during a span
+type SpanEvent struct {
+	Time                   time.Time              `json:"time"`
+	Name                   string                 `json:"name"`
+	Attributes             map[string]interface{} `json:"attributes,omitempty"`
+	DroppedAttributesCount uint64                 `json:"dropped_attributes_count,omitempty"`
+}
+
+// SpanLink is a link from a span to another span, possibly in another trace
+type SpanLink struct {
+	TraceID                string                 `json:"trace_id"`
+	SpanID                 string                 `json:"span_id"`
+	TraceState             string                 `json:"trace_state,omitempty"`
+	Attributes             map[string]interface{} `json:"attributes,omitempty"`
+	DroppedAttributesCount uint64                 `json:"dropped_attributes_count,omitempty"`
+}
+
+// SpanStatus is the result of a span; code 0 is unset, 1 is ok and 2 is error
+type SpanStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// DecodeTraces decodes all ctraces contexts in the payload
+//
+// Keys are accepted in both snake_case and camelCase, e.g. "scope_spans" and "scopeSpans".
+func DecodeTraces(payload []byte) ([]TracesContext, error) {
+	var contexts []TracesContext
+	err := iterateContexts(payload, func(value interface{}) error {
+		root := toObject(value)
+		if root == nil || (!root.has("resourceSpans") && !root.has("resource_spans")) {
+			return fmt.Errorf("invalid traces context: %T", value)
+		}
+		list := root.list("resourceSpans", "resource_spans")
+		context := TracesContext{
+			ResourceSpans: make([]ResourceSpans, 0, len(list)),
+		}
+		for _, item := range list {
+			if obj := toObject(item); obj != nil {
+				context.ResourceSpans = append(context.ResourceSpans, decodeResourceSpans(obj))
+			}
+		}
+		contexts = append(contexts, context)
+		return nil
+	})
+	return contexts, err
+}
+
+func decodeResourceSpans(obj object) ResourceSpans {
+	resource := obj.obj("resource")
+	list := obj.list("scopeSpans", "scope_spans")
+	rs := ResourceSpans{
+		Resource: Resource{
+			Attributes:             resource.attributes("attributes"),
+			DroppedAttributesCount: resource.uint("dropped_attributes_count", "droppedAttributesCount"),
+		},
+		SchemaURL:  obj.str("schema_url", "schemaUrl"),
+		ScopeSpans: make([]ScopeSpans, 0, len(list)),
+	}
+	for _, item := range list {
+		if scopeObj := toObject(item); scopeObj != nil {
+			rs.ScopeSpans = append(rs.ScopeSpans, decodeScopeSpans(scopeObj))
+		}
+	}
+	return rs
+}
+
+func decodeScopeSpans(obj object) ScopeSpans {
+	scope := obj.obj("scope")
+	list := obj.list("spans")
+	ss := ScopeSpans{
+		Scope: Scope{
+			Name:                   scope.str("name"),
+			Version:                scope.str("version"),
+			Attributes:             scope.attributes("attributes"),
+			DroppedAttributesCount: scope.uint("dropped_attributes_count", "droppedAttributesCount"),
+		},
+		SchemaURL: obj.str("schema_url", "schemaUrl"),
+		Spans:     make([]Span, 0, len(list)),
+	}
+	for _, item := range list {
+		if spanObj := toObject(item); spanObj != nil {
+			ss.Spans = append(ss.Spans, decodeSpan(spanObj))
+		}
+	}
+	return ss
+}
+
+func decodeSpan(obj object) Span {
+	status := obj.obj("status")
+	span := Span{
+		TraceID:                obj.hexID("trace_id", "traceId"),
+		SpanID:                 obj.hexID("span_id", "spanId"),
+		ParentSpanID:           obj.hexID("parent_span_id", "parentSpanId"),
+		TraceState:             obj.str("trace_state", "traceState"),
+		Name:                   obj.str("name"),
+		Kind:                   SpanKind(obj.uint("kind")),
+		StartTime:              time.Unix(0, int64(obj.uint("start_time_unix_nano", "startTimeUnixNano", "start_time"))),
+		EndTime:                time.Unix(0, int64(obj.uint("end_time_unix_nano", "endTimeUnixNano", "end_time"))),
+		Attributes:             obj.attributes("attributes"),
+		DroppedAttributesCount: obj.uint("dropped_attributes_count", "droppedAttributesCount"),
+		Events:                 nil,
+		DroppedEventsCount:     obj.uint("dropped_events_count", "droppedEventsCount"),
+		Links:                  nil,
+		DroppedLinksCount:      obj.uint("dropped_links_count", "droppedLinksCount"),
+		Status: SpanStatus{
+			Code:    int(status.uint("code")),
+			Message: status.str("message"),
+		},
+	}
+	for _, item := range obj.list("events") {
+		if eventObj := toObject(item); eventObj != nil {
+			span.Events = append(span.Events, SpanEvent{
+				Time:                   time.Unix(0, int64(eventObj.uint("time_unix_nano", "timeUnixNano", "time"))),
+				Name:                   eventObj.str("name"),
+				Attributes:             eventObj.attributes("attributes"),
+				DroppedAttributesCount: eventObj.uint("dropped_attributes_count", "droppedAttributesCount"),
+			})
+		}
+	}
+	for _, item := range obj.list("links") {
+		if linkObj := toObject(item); linkObj != nil {
+			span.Links = append(span.Links, SpanLink{
+				TraceID:                linkObj.hexID("trace_id", "traceId"),
+				SpanID:                 linkObj.hexID("span_id", "spanId"),
+				TraceState:             linkObj.str("trace_state", "traceState"),
+				Attributes:             linkObj.attributes("attributes"),
+				DroppedAttributesCount: linkObj.uint("dropped_attributes_count", "droppedAttributesCount"),
+			})
+		}
+	}
+	return span
+}
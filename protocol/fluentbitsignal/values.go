@@ -0,0 +1,153 @@
+package fluentbitsignal
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/relex/fluentlib/util"
+)
+
+// object is a generic msgpack map with helpers for tolerant decoding
+//
+// Missing keys and unexpected types result in zero values, since the schemas are not versioned strictly upstream
+type object map[string]interface{}
+
+// toObject converts a generic msgpack map to object, or nil if it's not a map
+func toObject(value interface{}) object {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v
+	case map[interface{}]interface{}:
+		obj := make(object, len(v))
+		for key, val := range v {
+			obj[fmt.Sprint(key)] = val
+		}
+		return obj
+	default:
+		return nil
+	}
+}
+
+// lookup returns the value of the first existing key among the given keys
+func (o object) lookup(keys ...string) interface{} {
+	for _, key := range keys {
+		if value, exists := o[key]; exists {
+			return value
+		}
+	}
+	return nil
+}
+
+func (o object) has(key string) bool {
+	_, exists := o[key]
+	return exists
+}
+
+func (o object) str(keys ...string) string {
+	switch v := o.lookup(keys...).(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
+
+func (o object) uint(keys ...string) uint64 {
+	v, _ := util.ToUint64(o.lookup(keys...))
+	return v
+}
+
+func (o object) float(keys ...string) float64 {
+	v, _ := util.ToFloat64(o.lookup(keys...))
+	return v
+}
+
+func (o object) obj(keys ...string) object {
+	return toObject(o.lookup(keys...))
+}
+
+func (o object) list(keys ...string) []interface{} {
+	v, _ := o.lookup(keys...).([]interface{})
+	return v
+}
+
+// attributes converts a generic msgpack map to attributes with string keys, recursively for nested maps
+func (o object) attributes(keys ...string) map[string]interface{} {
+	obj := o.obj(keys...)
+	if obj == nil {
+		return nil
+	}
+	attrs := make(map[string]interface{}, len(obj))
+	for key, value := range obj {
+		attrs[key] = normalizeValue(value)
+	}
+	return attrs
+}
+
+// normalizeValue converts nested maps with non-string keys to map[string]interface{}, in order to be JSON-compatible
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		obj := toObject(v)
+		m := make(map[string]interface{}, len(obj))
+		for key, val := range obj {
+			m[key] = normalizeValue(val)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, val := range v {
+			list[i] = normalizeValue(val)
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+func toStringList(values []interface{}) []string {
+	list := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case string:
+			list[i] = v
+		case []byte:
+			list[i] = string(v)
+		case nil:
+			list[i] = ""
+		default:
+			list[i] = fmt.Sprint(v)
+		}
+	}
+	return list
+}
+
+func toFloatList(values []interface{}) []float64 {
+	list := make([]float64, len(values))
+	for i, value := range values {
+		list[i], _ = util.ToFloat64(value)
+	}
+	return list
+}
+
+func toUintList(values []interface{}) []uint64 {
+	list := make([]uint64, len(values))
+	for i, value := range values {
+		list[i], _ = util.ToUint64(value)
+	}
+	return list
+}
+
+// hexID returns binary IDs in lowercase hex, or string IDs as-is
+func (o object) hexID(keys ...string) string {
+	switch v := o.lookup(keys...).(type) {
+	case []byte:
+		return hex.EncodeToString(v)
+	case string:
+		return v
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,19 @@
+package forwardprotocol
+
+import (
+	"strings"
+)
+
+// UnixAddressPrefix is the prefix of Unix socket addresses, e.g. "unix:///var/run/fluent.sock"
+const UnixAddressPrefix = "unix://"
+
+// ParseAddress splits the given address into network and address for net.Dial and net.Listen
+//
+// "unix:///path/to/socket" is a Unix socket and "unix://@name" is an abstract Unix socket on Linux. Others are TCP
+// addresses as "host:port".
+func ParseAddress(address string) (string, string) {
+	if strings.HasPrefix(address, UnixAddressPrefix) {
+		return "unix", strings.TrimPrefix(address, UnixAddressPrefix)
+	}
+	return "tcp", address
+}
@@ -0,0 +1,226 @@
+package forwardprotocol
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/relex/gotils/logger"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// ClientConfig contains configuration for ForwardClient
+//
+// Zero durations are replaced by defaults from clientDefs
+type ClientConfig struct {
+	Address          string        // Address of server, e.g. "localhost:24224" or "unix:///var/run/fluent.sock"
+	TLS              bool          // Enable TLS or not
+	TLSConfig        *tls.Config   // Custom TLS configuration, nil to use the default. Empty ServerName is set from Address.
+	SharedKey        string        // Shared key for handshake, empty to skip handshake
+	Username         string        // Username for user authentication if required by server
+	Password         string        // Password for user authentication if required by server
+	ClientHostname   string        // Hostname sent in handshake, empty to use os.Hostname()
+	ServerHostname   string        // Server hostname expected in handshake, empty to accept any
+	Mode             MessageMode   // Mode to encode messages, default to ModeForward
+	RequireAck       bool          // Generate chunk IDs and wait for Ack from server
+	DialTimeout      time.Duration // Timeout to establish connection
+	HandshakeTimeout time.Duration // Timeout to complete handshake
+	WriteTimeout     time.Duration // Timeout to write a message
+	AckTimeout       time.Duration // Timeout to receive Ack after a message is written
+	MaxRetries       int           // Max retries of sending a message, 0 for no retry
+	RetryInterval    time.Duration // Interval between retries
+}
+
+var clientDefs = struct {
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+	WriteTimeout     time.Duration
+	AckTimeout       time.Duration
+	RetryInterval    time.Duration
+}{
+	DialTimeout:      10 * time.Second,
+	HandshakeTimeout: 10 * time.Second,
+	WriteTimeout:     30 * time.Second,
+	AckTimeout:       30 * time.Second,
+	RetryInterval:    1 * time.Second,
+}
+
+// ForwardClient is a client for Fluentd Forward protocol
+//
+// The client connects on demand and reconnects when sending fails. It's not safe for concurrent use.
+type ForwardClient struct {
+	logger  logger.Logger
+	config  ClientConfig
+	conn    net.Conn
+	decoder *msgpack.Decoder
+}
+
+// NewForwardClient creates a new client without connecting
+func NewForwardClient(parentLogger logger.Logger, config ClientConfig) *ForwardClient {
+	if config.Mode == "" {
+		config.Mode = ModeForward
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = clientDefs.DialTimeout
+	}
+	if config.HandshakeTimeout == 0 {
+		config.HandshakeTimeout = clientDefs.HandshakeTimeout
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = clientDefs.WriteTimeout
+	}
+	if config.AckTimeout == 0 {
+		config.AckTimeout = clientDefs.AckTimeout
+	}
+	if config.RetryInterval == 0 {
+		config.RetryInterval = clientDefs.RetryInterval
+	}
+	return &ForwardClient{
+		logger: parentLogger.WithFields(logger.Fields{
+			"component": "FluentdForwardClient",
+			"address":   config.Address,
+		}),
+		config: config,
+	}
+}
+
+// NewChunkID generates a random chunk ID in the same format as Fluentd (base64 of 16 random bytes)
+func NewChunkID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		logger.Panic("failed to read crypto/rand: ", err)
+	}
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+// Connect establishes connection and performs handshake if not connected yet
+func (client *ForwardClient) Connect() error {
+	if client.conn != nil {
+		return nil
+	}
+	network, address := ParseAddress(client.config.Address)
+	conn, err := net.DialTimeout(network, address, client.config.DialTimeout)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	if client.config.TLS {
+		conn = tls.Client(conn, makeClientTLSConfig(client.config.TLSConfig, network, address))
+	}
+	if len(client.config.SharedKey) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), client.config.HandshakeTimeout)
+		_, err := DoClientHandshake(ctx, conn, ClientHandshakeOptions{
+			SharedKey:              client.config.SharedKey,
+			Username:               client.config.Username,
+			Password:               client.config.Password,
+			ClientHostname:         client.config.ClientHostname,
+			SaltSource:             nil,
+			ExpectedServerHostname: client.config.ServerHostname,
+		})
+		cancel()
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("handshake: %w", err)
+		}
+	}
+	client.conn = conn
+	client.decoder = msgpack.NewDecoder(conn)
+	client.logger.Debug("connected")
+	return nil
+}
+
+// makeClientTLSConfig returns a copy of the given TLS config or the default, with ServerName set to the host of TCP
+// address if missing, as required by crypto/tls to verify the server
+func makeClientTLSConfig(config *tls.Config, network string, address string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName != "" || network != "tcp" {
+		return config
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return config
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
+// Send sends a batch of events under the given tag, see SendMessage
+func (client *ForwardClient) Send(tag string, entries []EventEntry) error {
+	return client.SendMessage(Message{
+		Tag:     tag,
+		Entries: entries,
+		Option:  TransportOption{},
+	})
+}
+
+// SendMessage sends the message in the configured mode and waits for Ack if required
+//
+// If RequireAck is set and the message has no chunk ID, a new one is generated. The same chunk ID is used for all
+// retries. On failure the connection is closed and reopened for the next attempt.
+func (client *ForwardClient) SendMessage(message Message) error {
+	if client.config.RequireAck && message.Option.Chunk == "" {
+		message.Option.Chunk = NewChunkID()
+	}
+	binary, encErr := MarshalMessage(message, client.config.Mode)
+	if encErr != nil {
+		return fmt.Errorf("encode: %w", encErr)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= client.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			client.logger.Warnf("retry sending chunk '%s' (%d/%d): %v", message.Option.Chunk, attempt, client.config.MaxRetries, lastErr)
+			time.Sleep(client.config.RetryInterval)
+		}
+		if lastErr = client.trySend(binary, message.Option.Chunk); lastErr == nil {
+			return nil
+		}
+		client.Close()
+	}
+	return fmt.Errorf("failed after %d attempts: %w", client.config.MaxRetries+1, lastErr)
+}
+
+// Close closes the current connection if any
+func (client *ForwardClient) Close() error {
+	if client.conn == nil {
+		return nil
+	}
+	err := client.conn.Close()
+	client.conn = nil
+	client.decoder = nil
+	return err
+}
+
+func (client *ForwardClient) trySend(binary []byte, chunkID string) error {
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	if err := client.conn.SetWriteDeadline(time.Now().Add(client.config.WriteTimeout)); err != nil {
+		return fmt.Errorf("set write timeout: %w", err)
+	}
+	if _, err := client.conn.Write(binary); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if !client.config.RequireAck {
+		return nil
+	}
+	if err := client.conn.SetReadDeadline(time.Now().Add(client.config.AckTimeout)); err != nil {
+		return fmt.Errorf("set read timeout: %w", err)
+	}
+	var ack Ack
+	if err := client.decoder.Decode(&ack); err != nil {
+		return fmt.Errorf("read ack: %w", err)
+	}
+	if ack.Ack != chunkID {
+		return errors.New("ack mismatch: got '" + ack.Ack + "', wanted '" + chunkID + "'")
+	}
+	client.logger.Debugf("received ack '%s'", ack.Ack)
+	return nil
+}
@@ -0,0 +1,225 @@
+package forwardprotocol
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/relex/gotils/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// newTestCertificate creates a self-signed certificate for localhost
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+// launchTLSSink accepts TLS connections and discards everything received
+func launchTLSSink(t *testing.T, cert tls.Certificate) net.Listener {
+	lsnr, err := tls.Listen("tcp", "localhost:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	assert.Nil(t, err)
+	go func() {
+		for {
+			conn, err := lsnr.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return lsnr
+}
+
+func TestClientTLS(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+	lsnr := launchTLSSink(t, cert)
+	defer lsnr.Close()
+	_, port, _ := net.SplitHostPort(lsnr.Addr().String())
+	address := net.JoinHostPort("localhost", port)
+	entries := []EventEntry{
+		{
+			Time:   EventTime{Time: time.Date(2020, 10, 31, 1, 2, 3, 4, time.UTC)},
+			Record: map[string]interface{}{"field1": "foo"},
+		},
+	}
+
+	// the default config verifies the server by system CAs, against the hostname from address
+	client := NewForwardClient(logger.WithField("test", t.Name()), ClientConfig{
+		Address:   address,
+		TLS:       true,
+		TLSConfig: nil,
+	})
+	err := client.Send("hello", entries)
+	assert.ErrorContains(t, err, "certificate")
+	assert.NotContains(t, err.Error(), "ServerName")
+	client.Close()
+
+	// custom config without ServerName is copied instead of modified
+	tlsConfig := &tls.Config{RootCAs: pool}
+	client = NewForwardClient(logger.WithField("test", t.Name()), ClientConfig{
+		Address:   address,
+		TLS:       true,
+		TLSConfig: tlsConfig,
+	})
+	assert.Nil(t, client.Send("hello", entries))
+	assert.Equal(t, "", tlsConfig.ServerName)
+	client.Close()
+
+	assert.Equal(t, "example.com", makeClientTLSConfig(nil, "tcp", "example.com:24224").ServerName)
+	assert.Equal(t, "::1", makeClientTLSConfig(nil, "tcp", "[::1]:24224").ServerName)
+	assert.Equal(t, "", makeClientTLSConfig(nil, "unix", "/var/run/fluent.sock").ServerName)
+	assert.Equal(t, "other", makeClientTLSConfig(&tls.Config{ServerName: "other"}, "tcp", "example.com:24224").ServerName)
+}
+
+// launchAckServer handshakes and decodes messages on each connection in turn, and responds by the given function
+//
+// The function is called with 1-based connection numbers and returns the chunk ID to ack, or false to close connection
+func launchAckServer(t *testing.T, sharedKey string, respond func(conn int, message Message) (string, bool)) (net.Listener, <-chan Message) {
+	lsnr, err := net.Listen("tcp", "localhost:0")
+	assert.Nil(t, err)
+	received := make(chan Message, 10)
+	go func() {
+		for n := 1; ; n++ {
+			conn, err := lsnr.Accept()
+			if err != nil {
+				return
+			}
+			func() {
+				defer conn.Close()
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if _, err := DoServerHandshake(ctx, conn, ServerHandshakeOptions{SharedKey: sharedKey}); err != nil {
+					return
+				}
+				decoder := NewMessageDecoder(conn, DecodeOptions{})
+				encoder := msgpack.NewEncoder(conn)
+				for {
+					message, err := decoder.Decode()
+					if err != nil {
+						return
+					}
+					received <- message
+					ack, ok := respond(n, message)
+					if !ok || encoder.Encode(&Ack{Ack: ack}) != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return lsnr, received
+}
+
+func TestClientSend(t *testing.T) {
+	entries := []EventEntry{
+		{
+			Time:   EventTime{Time: time.Date(2020, 10, 31, 1, 2, 3, 4, time.UTC)},
+			Record: map[string]interface{}{"field1": "foo"},
+		},
+	}
+	for _, mode := range []MessageMode{ModeMessage, ModeForward, ModePackedForward, ModeCompressedPackedForward} {
+		lsnr, received := launchAckServer(t, "hi", func(conn int, message Message) (string, bool) {
+			return message.Option.Chunk, true
+		})
+		client := NewForwardClient(logger.WithField("test", t.Name()), ClientConfig{
+			Address:    lsnr.Addr().String(),
+			SharedKey:  "hi",
+			Mode:       mode,
+			RequireAck: true,
+		})
+		assert.Nil(t, client.Send("hello", entries), mode)
+		message := <-received
+		assert.Equal(t, "hello", message.Tag, mode)
+		assert.NotEmpty(t, message.Option.Chunk, mode)
+		if assert.Len(t, message.Entries, 1, mode) {
+			assert.Equal(t, entries[0].Record, message.Entries[0].Record, mode)
+		}
+		client.Close()
+		lsnr.Close()
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	entries := []EventEntry{
+		{
+			Time:   EventTime{Time: time.Date(2020, 10, 31, 1, 2, 3, 4, time.UTC)},
+			Record: map[string]interface{}{"field1": "foo"},
+		},
+	}
+
+	// the first connection is closed without ack, and the chunk is resent with the same ID on a new connection
+	lsnr, received := launchAckServer(t, "hi", func(conn int, message Message) (string, bool) {
+		return message.Option.Chunk, conn > 1
+	})
+	defer lsnr.Close()
+	client := NewForwardClient(logger.WithField("test", t.Name()), ClientConfig{
+		Address:       lsnr.Addr().String(),
+		SharedKey:     "hi",
+		RequireAck:    true,
+		MaxRetries:    1,
+		RetryInterval: time.Millisecond,
+	})
+	assert.Nil(t, client.Send("hello", entries))
+	first, second := <-received, <-received
+	assert.Equal(t, first.Option.Chunk, second.Option.Chunk)
+	client.Close() // connections are served one by one
+
+	// wrong acks fail after retries
+	wrongLsnr, _ := launchAckServer(t, "hi", func(conn int, message Message) (string, bool) {
+		return "wrong", true
+	})
+	defer wrongLsnr.Close()
+	wrongClient := NewForwardClient(logger.WithField("test", t.Name()), ClientConfig{
+		Address:       wrongLsnr.Addr().String(),
+		SharedKey:     "hi",
+		RequireAck:    true,
+		MaxRetries:    2,
+		RetryInterval: time.Millisecond,
+	})
+	defer wrongClient.Close()
+	err := wrongClient.Send("hello", entries)
+	assert.ErrorContains(t, err, "failed after 3 attempts: ack mismatch: got 'wrong'")
+
+	// handshake failures are returned as such
+	badKeyClient := NewForwardClient(logger.WithField("test", t.Name()), ClientConfig{
+		Address:    lsnr.Addr().String(),
+		SharedKey:  "bye",
+		RequireAck: true,
+	})
+	defer badKeyClient.Close()
+	err = badKeyClient.Send("hello", entries)
+	assert.True(t, errors.Is(err, ErrAuthRejected), err)
+}
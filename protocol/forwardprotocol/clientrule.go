@@ -0,0 +1,73 @@
+package forwardprotocol
+
+import (
+	"net"
+	"path"
+)
+
+// ClientRule restricts clients by network or hostname during handshake, same as <client> in Fluentd's <security>
+//
+// A client matches the rule if both Network and Hostname match. The first matched rule in
+// ServerHandshakeOptions.ClientRules is applied.
+type ClientRule struct {
+	Network   *net.IPNet // Network of client address, nil to match any
+	Hostname  string     // Glob pattern of client hostname in PING, e.g. "web-*", empty to match any
+	SharedKey string     // Shared key required for matched clients, empty to use the keys in ServerHandshakeOptions
+	Usernames []string   // Users allowed for matched clients if user authentication is required, empty to allow all
+}
+
+// Match checks whether the client of the given address and hostname matches this rule
+func (rule ClientRule) Match(addr net.Addr, hostname string) bool {
+	if rule.Network != nil {
+		ip := addrToIP(addr)
+		if ip == nil || !rule.Network.Contains(ip) {
+			return false
+		}
+	}
+	if rule.Hostname != "" {
+		if matched, err := path.Match(rule.Hostname, hostname); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// allowUser checks whether the given user is allowed by this rule
+func (rule ClientRule) allowUser(username string) bool {
+	if len(rule.Usernames) == 0 {
+		return true
+	}
+	for _, name := range rule.Usernames {
+		if name == username {
+			return true
+		}
+	}
+	return false
+}
+
+// findClientRule returns the first rule matched by the given client, or nil
+func findClientRule(rules []ClientRule, addr net.Addr, hostname string) *ClientRule {
+	for i := range rules {
+		if rules[i].Match(addr, hostname) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// addrToIP extracts IP from a network address, or returns nil if it's not an IP address
+func addrToIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
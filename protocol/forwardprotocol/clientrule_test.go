@@ -0,0 +1,73 @@
+package forwardprotocol
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRuleMatch(t *testing.T) {
+	_, network, err := net.ParseCIDR("10.1.0.0/16")
+	assert.Nil(t, err)
+	rule := ClientRule{Network: network, Hostname: "web-*", SharedKey: "", Usernames: nil}
+
+	assert.True(t, rule.Match(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234, Zone: ""}, "web-1"))
+	assert.False(t, rule.Match(&net.TCPAddr{IP: net.ParseIP("10.2.2.3"), Port: 1234, Zone: ""}, "web-1"))
+	assert.False(t, rule.Match(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234, Zone: ""}, "db-1"))
+	assert.False(t, rule.Match(&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, "web-1"))
+	assert.True(t, ClientRule{Network: nil, Hostname: "", SharedKey: "", Usernames: nil}.Match(nil, ""))
+}
+
+func TestHandshakeClientRules(t *testing.T) {
+	serverOptions := ServerHandshakeOptions{
+		SharedKey:  "key",
+		SharedKeys: nil,
+		Users:      map[string]string{"alice": "wonderland", "bob": "builder"},
+		Auth:       nil,
+		ClientRules: []ClientRule{
+			{Network: nil, Hostname: "web-*", SharedKey: "web-key", Usernames: []string{"alice"}},
+			{Network: nil, Hostname: "db-*", SharedKey: "", Usernames: nil},
+		},
+		AllowAnonymous: false,
+		ServerHostname: "server",
+	}
+
+	type test struct {
+		hostname  string
+		sharedKey string
+		username  string
+		password  string
+		reason    string
+	}
+	testList := []test{
+		{"web-1", "web-key", "alice", "wonderland", ""},
+		{"web-1", "key", "alice", "wonderland", "shared_key mismatch"},
+		{"web-1", "web-key", "bob", "builder", "username/password mismatch"},
+		{"db-1", "key", "bob", "builder", ""},
+		{"app-1", "key", "bob", "builder", "anonymous source host 'pipe' denied"},
+	}
+	for i, test := range testList {
+		clientConn, serverConn := net.Pipe()
+		go func() {
+			_, _ = DoServerHandshake(context.Background(), serverConn, serverOptions)
+		}()
+		_, err := DoClientHandshake(context.Background(), clientConn, ClientHandshakeOptions{
+			SharedKey:              test.sharedKey,
+			Username:               test.username,
+			Password:               test.password,
+			ClientHostname:         test.hostname,
+			SaltSource:             nil,
+			ExpectedServerHostname: "",
+		})
+		if test.reason == "" {
+			assert.Nil(t, err, "test[%d]", i)
+		} else {
+			assert.ErrorIs(t, err, ErrAuthRejected, "test[%d]", i)
+			assert.ErrorContains(t, err, test.reason, "test[%d]", i)
+		}
+		clientConn.Close()
+		serverConn.Close()
+	}
+}
@@ -1,8 +1,10 @@
 package forwardprotocol
 
 import (
+	"crypto/rand"
 	"crypto/sha512"
 	"encoding/hex"
+	"io"
 
 	"github.com/relex/gotils/logger"
 )
@@ -16,3 +18,27 @@ func sha512ToHexdigest(content string) string {
 	hash := hasher.Sum(nil)
 	return hex.EncodeToString(hash)
 }
+
+// makePasswordHexdigest computes the password digest sent in PING for user authentication
+func makePasswordHexdigest(authSalt []byte, username string, password string) string {
+	return sha512ToHexdigest(string(authSalt) + username + password)
+}
+
+// newRandomBytes creates a random nonce or salt of the given size, same as Fluentd's generate_salt with 16 bytes
+//
+// The source is crypto/rand if nil
+func newRandomBytes(source io.Reader, size int) ([]byte, error) {
+	if source == nil {
+		source = rand.Reader
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(source, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// makeSharedKeyHexdigest computes the shared key digest sent in PING and PONG
+func makeSharedKeyHexdigest(salt []byte, hostname string, nonce []byte, sharedKey string) string {
+	return sha512ToHexdigest(string(salt) + hostname + string(nonce) + sharedKey)
+}
@@ -0,0 +1,284 @@
+package forwardprotocol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v4"
+	"github.com/vmihailenco/msgpack/v4/codes"
+)
+
+// maxPreallocatedItems limits the preallocation of entries and maps by counts claimed in input
+const maxPreallocatedItems = 1000
+
+// LimitError is returned when decoding exceeds a limit in DecodeOptions
+type LimitError struct {
+	Limit  string // Name of the limit in DecodeOptions, e.g. "MaxEntries"
+	Max    int    // Configured limit
+	Actual int    // Actual value at the time of detection, which may be lower than the real one
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s exceeded: %d > %d", e.Limit, e.Actual, e.Max)
+}
+
+// MessageDecoder decodes messages one after another from a stream, e.g. a connection
+//
+// Unlike DecodeMessage, it counts the bytes of each message in order to enforce DecodeOptions.MaxMessageBytes
+type MessageDecoder struct {
+	reader  *limitedReader
+	decoder *msgpack.Decoder
+	options DecodeOptions
+}
+
+// NewMessageDecoder creates a MessageDecoder reading from the given reader with its own buffering
+func NewMessageDecoder(reader io.Reader, options DecodeOptions) *MessageDecoder {
+	lreader := newLimitedReader(reader, options.MaxMessageBytes, "MaxMessageBytes")
+	return &MessageDecoder{
+		reader:  lreader,
+		decoder: msgpack.NewDecoder(lreader),
+		options: options,
+	}
+}
+
+// Decode decodes the next message, or returns error including *LimitError
+//
+// The stream cannot be recovered after any error
+func (d *MessageDecoder) Decode() (Message, error) {
+	d.reader.reset()
+	return DecodeMessage(d.decoder, d.options)
+}
+
+// limitedReader is a buffered reader which fails with *LimitError once the bytes read reach the max
+//
+// It implements io.ByteScanner so that msgpack.Decoder doesn't add buffering by itself and read beyond the limit
+type limitedReader struct {
+	reader *bufio.Reader
+	limit  string
+	max    int
+	count  int
+}
+
+func newLimitedReader(reader io.Reader, max int, limit string) *limitedReader {
+	return &limitedReader{
+		reader: bufio.NewReader(reader),
+		limit:  limit,
+		max:    max,
+		count:  0,
+	}
+}
+
+func (r *limitedReader) reset() {
+	r.count = 0
+}
+
+// resetTo starts counting a new message whose first bytes have already been read by the caller
+func (r *limitedReader) resetTo(count int) {
+	r.count = count
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.max > 0 {
+		remaining := r.max - r.count
+		if remaining <= 0 {
+			return 0, r.makeError()
+		}
+		if len(p) > remaining {
+			p = p[:remaining]
+		}
+	}
+	n, err := r.reader.Read(p)
+	r.count += n
+	return n, err
+}
+
+func (r *limitedReader) ReadByte() (byte, error) {
+	if r.max > 0 && r.count >= r.max {
+		return 0, r.makeError()
+	}
+	c, err := r.reader.ReadByte()
+	if err == nil {
+		r.count++
+	}
+	return c, err
+}
+
+func (r *limitedReader) UnreadByte() error {
+	if err := r.reader.UnreadByte(); err != nil {
+		return err
+	}
+	r.count--
+	return nil
+}
+
+func (r *limitedReader) makeError() error {
+	return &LimitError{
+		Limit:  r.limit,
+		Max:    r.max,
+		Actual: r.count + 1,
+	}
+}
+
+func (options DecodeOptions) hasValueLimits() bool {
+	return options.MaxNestingDepth > 0 || options.MaxStringLength > 0
+}
+
+func (options DecodeOptions) checkString(length int) error {
+	if options.MaxStringLength > 0 && length > options.MaxStringLength {
+		return &LimitError{
+			Limit:  "MaxStringLength",
+			Max:    options.MaxStringLength,
+			Actual: length,
+		}
+	}
+	return nil
+}
+
+func (options DecodeOptions) checkEntries(count int) error {
+	if options.MaxEntries > 0 && count > options.MaxEntries {
+		return &LimitError{
+			Limit:  "MaxEntries",
+			Max:    options.MaxEntries,
+			Actual: count,
+		}
+	}
+	return nil
+}
+
+// decodeRecord decodes a record or metadata map by the limits in options
+func decodeRecord(decoder *msgpack.Decoder, options DecodeOptions) (map[string]interface{}, error) {
+	if !options.hasValueLimits() {
+		var record map[string]interface{}
+		err := decoder.Decode(&record)
+		return record, err
+	}
+	return decodeMapValue(decoder, 1, options)
+}
+
+// decodeValue decodes any value in the same types as msgpack.Decoder.DecodeInterface, by the limits in options
+func decodeValue(decoder *msgpack.Decoder, depth int, options DecodeOptions) (interface{}, error) {
+	code, err := decoder.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case codes.IsFixedMap(code) || code == codes.Map16 || code == codes.Map32:
+		m, err := decodeMapValue(decoder, depth, options)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	case codes.IsFixedArray(code) || code == codes.Array16 || code == codes.Array32:
+		return decodeArrayValue(decoder, depth, options)
+	case codes.IsString(code) || codes.IsBin(code):
+		if options.MaxStringLength <= 0 {
+			return decoder.DecodeInterface()
+		}
+		data, err := decodeLimitedBytes(decoder, options)
+		if err != nil {
+			return nil, err
+		}
+		if codes.IsBin(code) {
+			return data, nil
+		}
+		return string(data), nil
+	default:
+		return decoder.DecodeInterface()
+	}
+}
+
+func decodeMapValue(decoder *msgpack.Decoder, depth int, options DecodeOptions) (map[string]interface{}, error) {
+	if err := checkDepth(depth, options); err != nil {
+		return nil, err
+	}
+	n, err := decoder.DecodeMapLen()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+	m := make(map[string]interface{}, minInt(n, maxPreallocatedItems))
+	for i := 0; i < n; i++ {
+		key, err := decodeString(decoder, options)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeValue(decoder, depth+1, options)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+func decodeArrayValue(decoder *msgpack.Decoder, depth int, options DecodeOptions) (interface{}, error) {
+	if err := checkDepth(depth, options); err != nil {
+		return nil, err
+	}
+	n, err := decoder.DecodeArrayLen()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+	list := make([]interface{}, 0, minInt(n, maxPreallocatedItems))
+	for i := 0; i < n; i++ {
+		value, err := decodeValue(decoder, depth+1, options)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+// decodeString decodes a string or binary value as string, with the length checked by MaxStringLength before allocation
+func decodeString(decoder *msgpack.Decoder, options DecodeOptions) (string, error) {
+	if options.MaxStringLength <= 0 {
+		return decoder.DecodeString()
+	}
+	data, err := decodeLimitedBytes(decoder, options)
+	return string(data), err
+}
+
+// decodeLimitedBytes decodes a string or binary value as bytes, with the length checked by MaxStringLength before allocation
+//
+// Nil is decoded as empty
+func decodeLimitedBytes(decoder *msgpack.Decoder, options DecodeOptions) ([]byte, error) {
+	n, err := decoder.DecodeBytesLen()
+	if err != nil {
+		return nil, err
+	}
+	if err := options.checkString(n); err != nil {
+		return nil, err
+	}
+	data := make([]byte, maxInt(n, 0))
+	// msgpack.Decoder has no method to read the content after its length, but Buffered returns the reader in use
+	if _, err := io.ReadFull(decoder.Buffered(), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func checkDepth(depth int, options DecodeOptions) error {
+	if options.MaxNestingDepth > 0 && depth > options.MaxNestingDepth {
+		return &LimitError{
+			Limit:  "MaxNestingDepth",
+			Max:    options.MaxNestingDepth,
+			Actual: depth,
+		}
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
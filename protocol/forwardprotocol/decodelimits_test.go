@@ -0,0 +1,139 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestDecodeLimits(t *testing.T) {
+	message := Message{
+		Tag: "foo.bar",
+		Entries: []EventEntry{
+			{
+				Time: EventTime{Time: time.Date(2022, 1, 14, 10, 30, 55, 123, time.UTC)},
+				Record: map[string]interface{}{
+					"msg":  strings.Repeat("x", 100),
+					"http": map[string]interface{}{"request": map[string]interface{}{"method": "GET"}},
+				},
+			},
+			{
+				Time:   EventTime{Time: time.Date(2022, 1, 14, 10, 30, 56, 456, time.UTC)},
+				Record: map[string]interface{}{"msg": "World"},
+			},
+		},
+	}
+	modes := []MessageMode{ModeForward, ModePackedForward, ModeCompressedPackedForward}
+
+	cases := []struct {
+		options DecodeOptions
+		limit   string
+	}{
+		{DecodeOptions{MaxEntries: 1}, "MaxEntries"},
+		{DecodeOptions{MaxNestingDepth: 2}, "MaxNestingDepth"},
+		{DecodeOptions{MaxStringLength: 99}, "MaxStringLength"},
+		{DecodeOptions{MaxDecompressedBytes: 50}, "MaxDecompressedBytes"},
+		{DecodeOptions{MaxMessageBytes: 100}, "MaxMessageBytes"},
+	}
+
+	for _, mode := range modes {
+		binary, err := MarshalMessage(message, mode)
+		assert.Nil(t, err, mode)
+
+		for _, c := range cases {
+			for _, lazy := range []bool{false, true} {
+				options := c.options
+				options.LazyEntries = lazy
+				msg, err := NewMessageDecoder(bytes.NewReader(binary), options).Decode()
+				if err == nil {
+					err = msg.ForEachEntry(func(EventEntry) error { return nil })
+				}
+				if c.limit == "MaxDecompressedBytes" && mode != ModeCompressedPackedForward {
+					assert.Nil(t, err, "%s %s lazy=%t", mode, c.limit, lazy)
+					continue
+				}
+				var limitErr *LimitError
+				if assert.True(t, errors.As(err, &limitErr), "%s %s lazy=%t: %v", mode, c.limit, lazy, err) {
+					assert.Equal(t, c.limit, limitErr.Limit, "%s %s lazy=%t", mode, c.limit, lazy)
+					assert.Greater(t, limitErr.Actual, limitErr.Max, "%s %s lazy=%t", mode, c.limit, lazy)
+				}
+			}
+		}
+
+		// within limits
+		decoded, err := NewMessageDecoder(bytes.NewReader(binary), DecodeOptions{
+			MaxMessageBytes:      len(binary),
+			MaxDecompressedBytes: 1000,
+			MaxEntries:           2,
+			MaxNestingDepth:      3,
+			MaxStringLength:      100,
+		}).Decode()
+		assert.Nil(t, err, mode)
+		assert.Equal(t, message.Entries[0].Record, decoded.Entries[0].Record, mode)
+	}
+}
+
+func TestMessageDecoderStream(t *testing.T) {
+	message := Message{
+		Tag: "foo",
+		Entries: []EventEntry{
+			{
+				Time:   EventTime{Time: time.Date(2022, 1, 14, 10, 30, 55, 0, time.UTC)},
+				Record: map[string]interface{}{"msg": "Hello"},
+			},
+		},
+	}
+	binary, err := MarshalMessage(message, ModeForward)
+	assert.Nil(t, err)
+
+	// the limit applies to each message separately
+	stream := bytes.Repeat(binary, 3)
+	decoder := NewMessageDecoder(bytes.NewReader(stream), DecodeOptions{MaxMessageBytes: len(binary)})
+	for i := 0; i < 3; i++ {
+		decoded, err := decoder.Decode()
+		assert.Nil(t, err, i)
+		assert.Equal(t, message.Entries[0].Record, decoded.Entries[0].Record, i)
+	}
+	_, err = decoder.Decode()
+	assert.Error(t, err)
+
+	// huge claimed entry count
+	hugeArray := []byte{0x93, 0xA3, 'f', 'o', 'o', 0xDD, 0x7F, 0xFF, 0xFF, 0xFF}
+	_, err = DecodeMessage(msgpack.NewDecoder(bytes.NewReader(hugeArray)), DecodeOptions{MaxEntries: 1000})
+	assert.EqualError(t, err, "message's entries as array of logs: MaxEntries exceeded: 2147483647 > 1000")
+
+	// huge claimed string lengths are rejected before reading
+	hugeStrings := map[string][]byte{
+		"message's tag: MaxStringLength exceeded: 2147483647 > 1000": {
+			0x93, 0xDB, 0x7F, 0xFF, 0xFF, 0xFF,
+		},
+		"message's record: MaxStringLength exceeded: 2147483647 > 1000": {
+			0x93, 0xA3, 'f', 'o', 'o', 0x01, 0x81, 0xDB, 0x7F, 0xFF, 0xFF, 0xFF,
+		},
+		"message's record: MaxStringLength exceeded: 16777216 > 1000": {
+			0x93, 0xA3, 'f', 'o', 'o', 0x01, 0x81, 0xA1, 'k', 0xC6, 0x01, 0x00, 0x00, 0x00,
+		},
+	}
+	for expectedErr, binary := range hugeStrings {
+		_, err = DecodeMessage(msgpack.NewDecoder(bytes.NewReader(binary)), DecodeOptions{MaxStringLength: 1000})
+		assert.EqualError(t, err, expectedErr)
+	}
+	// values within the limit are decoded as msgpack.Decoder.DecodeInterface does
+	decoded, err := DecodeMessage(msgpack.NewDecoder(bytes.NewReader([]byte{
+		0x93, 0xA3, 'f', 'o', 'o', 0x01, 0x83, 0xA1, 's', 0xD9, 0x02, 'h', 'i', 0xA1, 'b', 0xC4, 0x00, 0xA1, 'n', 0xC0,
+	})), DecodeOptions{MaxStringLength: 3})
+	assert.Nil(t, err)
+	if assert.Len(t, decoded.Entries, 1) {
+		assert.Equal(t, map[string]interface{}{"s": "hi", "b": []byte{}, "n": nil}, decoded.Entries[0].Record)
+	}
+	_, err = DecodeMessage(msgpack.NewDecoder(bytes.NewReader([]byte{
+		0x93, 0xA3, 'f', 'o', 'o', 0x01, 0x81, 0xA1, 's', 0xA2, 'h',
+	})), DecodeOptions{MaxStringLength: 3})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,206 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+var _ msgpack.Unmarshaler = (*rawValue)(nil)
+
+// lazyEntries holds undecoded entries of a message, see DecodeOptions.LazyEntries
+type lazyEntries struct {
+	data       []byte // Raw msgpack array of entries, or packed binary of entries
+	compressed bool   // Whether the packed binary is gzipped
+	isArray    bool   // Whether data is a msgpack array (Forward mode)
+	options    DecodeOptions
+}
+
+// rawValue captures the raw msgpack bytes of any single value
+//
+// The decoder records the bytes of a value before passing them to msgpack.Unmarshaler
+type rawValue []byte
+
+// UnmarshalMsgpack saves a copy of the raw bytes
+func (r *rawValue) UnmarshalMsgpack(b []byte) error {
+	*r = append((*r)[:0], b...)
+	return nil
+}
+
+// EntryIterator reads entries of a message one at a time
+//
+// For messages decoded with DecodeOptions.LazyEntries, each entry is decoded only when requested, from the gzip stream
+// if compressed, so that the whole batch never resides in memory. For other messages, Message.Entries is iterated.
+//
+// An iterator is not safe for concurrent use, but multiple iterators can be created on the same message.
+type EntryIterator struct {
+	entries   []EventEntry     // In-memory entries if the message is not lazy
+	decoder   *msgpack.Decoder // Decoder of lazy entries
+	options   DecodeOptions    // Options of lazy entries
+	zreader   *gzip.Reader     // Decompressor of lazy entries if compressed
+	remaining int              // Remaining entries in lazy array, or -1 for packed stream until EOF
+	index     int              // Index of the next entry
+}
+
+// IsLazy returns true if the message contains undecoded entries, which can only be read by EntryIterator
+func (msg *Message) IsLazy() bool {
+	return msg.lazy != nil
+}
+
+// EntryIterator creates an iterator over entries of the message
+//
+// The iterator must be closed after use
+func (msg *Message) EntryIterator() (*EntryIterator, error) {
+	it := &EntryIterator{
+		entries:   msg.Entries,
+		decoder:   nil,
+		options:   DecodeOptions{},
+		zreader:   nil,
+		remaining: -1,
+		index:     0,
+	}
+	if msg.lazy == nil {
+		return it, nil
+	}
+
+	it.options = msg.lazy.options
+	var reader io.Reader = bytes.NewReader(msg.lazy.data)
+	if msg.lazy.compressed {
+		zreader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("message's entries binary (compressed=true): %w", err)
+		}
+		it.zreader = zreader
+		reader = newLimitedReader(zreader, it.options.MaxDecompressedBytes, "MaxDecompressedBytes")
+	}
+	it.decoder = msgpack.NewDecoder(reader)
+	if msg.lazy.isArray {
+		count, err := it.decoder.DecodeArrayLen()
+		if err != nil {
+			it.Close()
+			return nil, fmt.Errorf("message's entries count: %w", err)
+		}
+		if err := it.options.checkEntries(count); err != nil {
+			it.Close()
+			return nil, fmt.Errorf("message's entries count: %w", err)
+		}
+		it.remaining = count
+	}
+	return it, nil
+}
+
+// Next decodes and returns the next entry, or io.EOF if there is no more
+func (it *EntryIterator) Next() (EventEntry, error) {
+	var entry EventEntry
+	if it.decoder == nil {
+		if it.index >= len(it.entries) {
+			return entry, io.EOF
+		}
+		it.index++
+		return it.entries[it.index-1], nil
+	}
+	if err := it.advance(); err != nil {
+		return entry, err
+	}
+	if err := decodeEventEntry(it.decoder, &entry, it.options); err != nil {
+		return entry, it.wrapError(err)
+	}
+	return entry, nil
+}
+
+// NextRaw returns the raw msgpack bytes of the next entry without decoding its record, or io.EOF if there is no more
+//
+// For lazy messages the bytes are in the original format sent by client. Otherwise the entry from Message.Entries is
+// encoded in the classic format [time, record]. DecodeOptions.MaxNestingDepth and MaxStringLength are not checked.
+func (it *EntryIterator) NextRaw() ([]byte, error) {
+	if it.decoder == nil {
+		entry, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		return msgpack.Marshal(entry)
+	}
+	if err := it.advance(); err != nil {
+		return nil, err
+	}
+	var raw rawValue
+	if err := it.decoder.Decode(&raw); err != nil {
+		return nil, it.wrapError(err)
+	}
+	return raw, nil
+}
+
+// Close releases resources of the iterator
+func (it *EntryIterator) Close() error {
+	if it.zreader != nil {
+		return it.zreader.Close()
+	}
+	return nil
+}
+
+func (it *EntryIterator) advance() error {
+	switch {
+	case it.remaining == 0:
+		return io.EOF
+	case it.remaining > 0:
+		it.remaining--
+	default:
+		// packed stream ends at EOF, which has to be checked before decoding entry
+		if _, err := it.decoder.PeekCode(); err != nil {
+			if err == io.EOF {
+				return io.EOF
+			}
+			return it.wrapError(err)
+		}
+	}
+	if err := it.options.checkEntries(it.index + 1); err != nil {
+		return err
+	}
+	it.index++
+	return nil
+}
+
+func (it *EntryIterator) wrapError(err error) error {
+	return fmt.Errorf("message's entry %d: %w", it.index-1, err)
+}
+
+// ForEachEntry iterates through all entries in the message by EntryIterator
+func (msg *Message) ForEachEntry(callback func(entry EventEntry) error) error {
+	it, err := msg.EntryIterator()
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for {
+		entry, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := callback(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// LoadEntries decodes all lazy entries into Entries, after which the message is no longer lazy
+func (msg *Message) LoadEntries() error {
+	if msg.lazy == nil {
+		return nil
+	}
+	entries := make([]EventEntry, 0, minInt(msg.Option.Size, maxPreallocatedItems))
+	if err := msg.ForEachEntry(func(entry EventEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}); err != nil {
+		return err
+	}
+	msg.Entries = entries
+	msg.lazy = nil
+	return nil
+}
@@ -0,0 +1,102 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestEntryIterator(t *testing.T) {
+	message := Message{
+		Tag: "foo.bar",
+		Entries: []EventEntry{
+			{
+				Time:   EventTime{Time: time.Date(2022, 1, 14, 10, 30, 55, 123, time.UTC)},
+				Record: map[string]interface{}{"msg": "Hello"},
+			},
+			{
+				Time:     EventTime{Time: time.Date(2022, 1, 14, 10, 30, 56, 456, time.UTC)},
+				Record:   map[string]interface{}{"msg": "World"},
+				Metadata: map[string]interface{}{"k": "v"},
+			},
+		},
+	}
+
+	for _, mode := range []MessageMode{ModeForward, ModePackedForward, ModeCompressedPackedForward} {
+		binary, err := MarshalMessageWithOptions(message, EncodeOptions{Mode: mode, EventFormatV2: true})
+		assert.Nil(t, err, mode)
+
+		decoded, err := DecodeMessage(msgpack.NewDecoder(bytes.NewReader(binary)), DecodeOptions{LazyEntries: true})
+		assert.Nil(t, err, mode)
+		assert.Equal(t, mode, decoded.Mode, mode)
+		assert.True(t, decoded.IsLazy(), mode)
+		assert.Nil(t, decoded.Entries, mode)
+
+		// decoded entries
+		it, err := decoded.EntryIterator()
+		assert.Nil(t, err, mode)
+		for i := range message.Entries {
+			entry, err := it.Next()
+			assert.Nil(t, err, "%s entries[%d]", mode, i)
+			assert.True(t, message.Entries[i].Time.Equal(entry.Time.Time), "%s entries[%d]", mode, i)
+			assert.Equal(t, message.Entries[i].Record, entry.Record, "%s entries[%d]", mode, i)
+		}
+		_, err = it.Next()
+		assert.Equal(t, io.EOF, err, mode)
+		assert.Nil(t, it.Close(), mode)
+
+		// raw entries
+		it, err = decoded.EntryIterator()
+		assert.Nil(t, err, mode)
+		for i := range message.Entries {
+			raw, err := it.NextRaw()
+			assert.Nil(t, err, "%s entries[%d]", mode, i)
+			expected := &bytes.Buffer{}
+			assert.Nil(t, EncodeEventEntry(msgpack.NewEncoder(expected), message.Entries[i], EncodeOptions{EventFormatV2: true}))
+			assert.Equal(t, expected.Bytes(), raw, "%s entries[%d]", mode, i)
+		}
+		_, err = it.NextRaw()
+		assert.Equal(t, io.EOF, err, mode)
+		assert.Nil(t, it.Close(), mode)
+
+		// re-encoding loads lazy entries
+		reencoded, err := MarshalMessage(decoded, ModeForward)
+		assert.Nil(t, err, mode)
+		var reloaded Message
+		assert.Nil(t, msgpack.Unmarshal(reencoded, &reloaded), mode)
+		assert.Len(t, reloaded.Entries, 2, mode)
+
+		assert.Nil(t, decoded.LoadEntries(), mode)
+		assert.False(t, decoded.IsLazy(), mode)
+		assert.Len(t, decoded.Entries, 2, mode)
+	}
+
+	// eager message
+	count := 0
+	assert.Nil(t, message.ForEachEntry(func(entry EventEntry) error {
+		assert.Equal(t, message.Entries[count].Record, entry.Record)
+		count++
+		return nil
+	}))
+	assert.Equal(t, 2, count)
+}
+
+func TestEntryIteratorCorrupted(t *testing.T) {
+	// ["foo", bin([1, <invalid>]), {}]
+	binary := []byte{0x93, 0xA3, 'f', 'o', 'o', 0xC4, 0x03, 0x92, 0x01, 0xC1, 0x80}
+
+	decoded, err := DecodeMessage(msgpack.NewDecoder(bytes.NewReader(binary)), DecodeOptions{LazyEntries: true})
+	assert.Nil(t, err)
+	assert.Equal(t, ModePackedForward, decoded.Mode)
+	err = decoded.ForEachEntry(func(entry EventEntry) error {
+		return nil
+	})
+	assert.ErrorContains(t, err, "message's entry 0: ")
+
+	_, err = DecodeMessage(msgpack.NewDecoder(bytes.NewReader(binary)), DecodeOptions{LazyEntries: false})
+	assert.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/relex/fluentlib/util"
@@ -11,10 +12,40 @@ import (
 )
 
 // EventTime represents the custom timestamp type used by Fluentd
+//
+// In decoding, EventTime accepts the ext type, integer and float seconds. The representation seen is saved in
+// EventEntry.TimeEncoding.
 type EventTime struct {
 	time.Time
 }
 
+// TimeEncoding is the msgpack representation of EventTime
+type TimeEncoding int
+
+const (
+	// TimeEncodingEventTime is the EventTime ext type 0 with nanoseconds, the default
+	TimeEncodingEventTime TimeEncoding = iota
+
+	// TimeEncodingInteger is integer seconds, e.g. from Fluentd with time_as_integer or many fluent-logger libraries
+	TimeEncodingInteger
+
+	// TimeEncodingFloat is float seconds from some older clients
+	TimeEncodingFloat
+)
+
+func (e TimeEncoding) String() string {
+	switch e {
+	case TimeEncodingEventTime:
+		return "eventtime"
+	case TimeEncodingInteger:
+		return "integer"
+	case TimeEncodingFloat:
+		return "float"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(e))
+	}
+}
+
 func init() {
 	msgpack.RegisterExt(0, (*EventTime)(nil))
 }
@@ -25,6 +56,8 @@ func (tm EventTime) MarshalJSON() ([]byte, error) {
 }
 
 // MarshalMsgpack encodes EventTime in msgpack format
+//
+// EventTime is always encoded as the ext type here. See EncodeOptions.TimeAsInteger for integer seconds.
 func (tm EventTime) MarshalMsgpack() ([]byte, error) {
 	// from https://godoc.org/github.com/vmihailenco/msgpack#example-RegisterExt
 	b := make([]byte, 8)
@@ -34,13 +67,38 @@ func (tm EventTime) MarshalMsgpack() ([]byte, error) {
 }
 
 // UnmarshalMsgpack decodes EventTime from msgpack bytes
+//
+// The bytes are either the 8-byte payload of the ext type, or a complete msgpack value of integer or float if the
+// value isn't an ext. No integer or float is encoded in exactly 8 bytes.
 func (tm *EventTime) UnmarshalMsgpack(b []byte) error {
-	// from https://godoc.org/github.com/vmihailenco/msgpack#example-RegisterExt
-	if len(b) != 8 {
-		return fmt.Errorf("invalid data length: got %d, wanted 8", len(b))
+	if len(b) == 8 {
+		// from https://godoc.org/github.com/vmihailenco/msgpack#example-RegisterExt
+		sec := binary.BigEndian.Uint32(b)
+		nsec := binary.BigEndian.Uint32(b[4:])
+		tm.Time = time.Unix(int64(sec), int64(nsec))
+		return nil
+	}
+
+	var value interface{}
+	if err := msgpack.Unmarshal(b, &value); err != nil {
+		return fmt.Errorf("invalid time: %w", err)
+	}
+	if sec, isInt := util.ToInt64(value); isInt {
+		tm.Time = time.Unix(sec, 0)
+		return nil
+	}
+	switch v := value.(type) {
+	case float32:
+		tm.Time = floatSecondsToTime(float64(v))
+	case float64:
+		tm.Time = floatSecondsToTime(v)
+	default:
+		return fmt.Errorf("invalid time type: %T", value)
 	}
-	sec := binary.BigEndian.Uint32(b)
-	nsec := binary.BigEndian.Uint32(b[4:])
-	tm.Time = time.Unix(int64(sec), int64(nsec))
 	return nil
 }
+
+func floatSecondsToTime(seconds float64) time.Time {
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9)))
+}
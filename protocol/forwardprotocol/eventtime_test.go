@@ -0,0 +1,64 @@
+package forwardprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestDecodeEventTime(t *testing.T) {
+	type test struct {
+		value    interface{}
+		time     time.Time
+		encoding TimeEncoding
+	}
+
+	testList := []test{
+		{&EventTime{Time: time.Unix(1642156255, 123456789)}, time.Unix(1642156255, 123456789), TimeEncodingEventTime},
+		{uint32(1642156255), time.Unix(1642156255, 0), TimeEncodingInteger},
+		{int64(1642156255), time.Unix(1642156255, 0), TimeEncodingInteger},
+		{uint8(100), time.Unix(100, 0), TimeEncodingInteger},
+		{1642156255.25, time.Unix(1642156255, 250000000), TimeEncodingFloat},
+	}
+
+	for i, test := range testList {
+		binary, err := msgpack.Marshal([]interface{}{test.value, map[string]interface{}{"msg": "Hello"}})
+		assert.Nil(t, err, "test[%d]", i)
+
+		var entry EventEntry
+		assert.Nil(t, msgpack.Unmarshal(binary, &entry), "test[%d]", i)
+		assert.Equal(t, EventTime{test.time}, entry.Time, "test[%d]", i) // comparable to constructed values
+		assert.Equal(t, test.encoding, entry.TimeEncoding, "test[%d]", i)
+	}
+
+	binary, err := msgpack.Marshal([]interface{}{"2022-01-14", map[string]interface{}{}})
+	assert.Nil(t, err)
+	var entry EventEntry
+	assert.EqualError(t, msgpack.Unmarshal(binary, &entry), "event's time: invalid time type: string")
+}
+
+func TestEncodeTimeAsInteger(t *testing.T) {
+	message := Message{
+		Tag: "foo",
+		Entries: []EventEntry{
+			{
+				Time:   EventTime{Time: time.Unix(1642156255, 123456789)},
+				Record: map[string]interface{}{"msg": "Hello"},
+			},
+		},
+	}
+
+	for _, mode := range []MessageMode{ModeMessage, ModeForward, ModeCompressedPackedForward} {
+		binary, err := MarshalMessageWithOptions(message, EncodeOptions{Mode: mode, TimeAsInteger: true})
+		assert.Nil(t, err, mode)
+
+		var decoded Message
+		assert.Nil(t, msgpack.Unmarshal(binary, &decoded), mode)
+		if assert.Len(t, decoded.Entries, 1, mode) {
+			assert.True(t, time.Unix(1642156255, 0).Equal(decoded.Entries[0].Time.Time), mode)
+			assert.Equal(t, TimeEncodingInteger, decoded.Entries[0].TimeEncoding, mode)
+		}
+	}
+}
@@ -1,5 +1,34 @@
 package forwardprotocol
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+var _ msgpack.CustomEncoder = HeloOptions{}
+
+// Errors returned from handshake, wrapped with details
+var (
+	ErrAuthRejected      = errors.New("authentication rejected")
+	ErrDigestMismatch    = errors.New("shared key digest mismatch")
+	ErrHostnameMismatch  = errors.New("unexpected server hostname")
+	ErrUnexpectedMessage = errors.New("unexpected handshake message")
+	ErrTimeout           = errors.New("handshake timeout")
+)
+
+// HandshakeResult contains information exchanged in a successful handshake
+type HandshakeResult struct {
+	PeerHostname string // Hostname of client in PING or server in PONG
+	Username     string // Username sent by client in PING, empty if user authentication isn't required
+	Tenant       string // Tenant of the shared key matched on server side, see ServerHandshakeOptions.SharedKeys
+	KeepAlive    bool   // keepalive option of server in HELO
+}
+
 // Helo is the HELO message from server to client during forward protocol handshake step 1
 type Helo struct {
 	_msgpack struct{}    `msgpack:",asArray"`
@@ -8,9 +37,11 @@ type Helo struct {
 }
 
 // HeloOptions is a map of options returned from fluent server
+//
+// Nonce and Auth are binary in the spec. Both bin and str are accepted in decoding.
 type HeloOptions struct {
-	Nonce     string `msgpack:"nonce"`
-	Auth      string `msgpack:"auth"`
+	Nonce     []byte `msgpack:"nonce"`
+	Auth      []byte `msgpack:"auth"` // Salt for user authentication, empty if not required
 	KeepAlive bool   `msgpack:"keepalive"`
 }
 
@@ -19,10 +50,10 @@ type Ping struct {
 	_msgpack           struct{} `msgpack:",asArray"`
 	Type               string   `msgpack:"type"`
 	ClientHostname     string   `msgpack:"client_hostname"`
-	SharedKeySalt      string   `msgpack:"shared_key_salt"`
+	SharedKeySalt      []byte   `msgpack:"shared_key_salt"` // Binary in the spec, both bin and str are accepted in decoding
 	SharedKeyHexdigest string   `msgpack:"shared_key_hexdigest"`
 	Username           string   `msgpack:"username"`
-	Password           string   `msgpack:"password"`
+	Password           string   `msgpack:"password"` // Hex SHA512 digest of auth salt, username and password
 }
 
 // Pong is the PONG message from server to client during forward protocol handshake step 3
@@ -40,3 +71,95 @@ func init() {
 	unusedStruct(Ping{}._msgpack)
 	unusedStruct(Pong{}._msgpack)
 }
+
+// EncodeMsgpack encodes HeloOptions as the spec: nonce and auth as bin, or auth as empty str if not required
+func (o HeloOptions) EncodeMsgpack(encoder *msgpack.Encoder) error {
+	if err := encoder.EncodeMapLen(3); err != nil {
+		return fmt.Errorf("HELO options' count: %w", err)
+	}
+	if err := encoder.EncodeString("nonce"); err != nil {
+		return err
+	}
+	if err := encoder.EncodeBytes(nonNilBytes(o.Nonce)); err != nil {
+		return fmt.Errorf("HELO nonce: %w", err)
+	}
+	if err := encoder.EncodeString("auth"); err != nil {
+		return err
+	}
+	if len(o.Auth) == 0 {
+		if err := encoder.EncodeString(""); err != nil {
+			return fmt.Errorf("HELO auth: %w", err)
+		}
+	} else if err := encoder.EncodeBytes(o.Auth); err != nil {
+		return fmt.Errorf("HELO auth: %w", err)
+	}
+	if err := encoder.EncodeString("keepalive"); err != nil {
+		return err
+	}
+	if err := encoder.EncodeBool(o.KeepAlive); err != nil {
+		return fmt.Errorf("HELO keepalive: %w", err)
+	}
+	return nil
+}
+
+// nonNilBytes prevents nil slices from being encoded as msgpack nil
+func nonNilBytes(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+// beginHandshake sets the deadline of conn from ctx and interrupts pending I/O once ctx is done
+//
+// The returned function must be called after handshake to stop watching ctx and clear the deadline
+func beginHandshake(ctx context.Context, conn net.Conn) (func(), error) {
+	deadline, _ := ctx.Deadline() // zero if not set
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("set deadline: %w", err)
+	}
+	stopChan := make(chan struct{})
+	stoppedChan := make(chan struct{})
+	go func() {
+		defer close(stoppedChan)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-stopChan:
+		}
+	}()
+	return func() {
+		close(stopChan)
+		<-stoppedChan
+		_ = conn.SetDeadline(time.Time{})
+	}, nil
+}
+
+// readHandshakeMessage reads the next handshake message from decoder into out
+//
+// Input which can be read but not decoded as the wanted message is ErrUnexpectedMessage
+func readHandshakeMessage(ctx context.Context, decoder *msgpack.Decoder, name string, out interface{}) error {
+	var raw rawValue
+	if err := decoder.Decode(&raw); err != nil {
+		return wrapHandshakeIOError(ctx, "read "+name, err)
+	}
+	if err := msgpack.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("%w: malformed %s: %v", ErrUnexpectedMessage, name, err)
+	}
+	return nil
+}
+
+// wrapHandshakeIOError converts errors caused by ctx or deadline to ErrTimeout or context errors
+func wrapHandshakeIOError(ctx context.Context, action string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return fmt.Errorf("%s: %w", action, ErrTimeout)
+		}
+		return fmt.Errorf("%s: %w", action, ctxErr)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%s: %w (%v)", action, ErrTimeout, err)
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
@@ -0,0 +1,255 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func acceptAll(hostname, username, password string) (bool, string) {
+	return true, ""
+}
+
+func TestHandshakeUserAuth(t *testing.T) {
+	users := map[string]string{"alice": "wonderland"}
+
+	type test struct {
+		username string
+		password string
+		success  bool
+		reason   string
+	}
+
+	testList := []test{
+		{"alice", "wonderland", true, ""},
+		{"alice", "looking-glass", false, "username/password mismatch"},
+		{"bob", "wonderland", false, "username/password mismatch"},
+	}
+
+	for i, test := range testList {
+		clientConn, serverConn := net.Pipe()
+		serverResult := make(chan error, 1)
+		go func() {
+			_, err := DoServerHandshake(context.Background(), serverConn, ServerHandshakeOptions{
+				SharedKey:      "key",
+				Users:          users,
+				Auth:           acceptAll,
+				ServerHostname: "server",
+			})
+			serverResult <- err
+		}()
+
+		result, err := DoClientHandshake(context.Background(), clientConn, ClientHandshakeOptions{
+			SharedKey:              "key",
+			Username:               test.username,
+			Password:               test.password,
+			ClientHostname:         "client",
+			SaltSource:             nil,
+			ExpectedServerHostname: "server",
+		})
+		serverErr := <-serverResult
+		if test.success {
+			assert.Nil(t, err, "test[%d]", i)
+			assert.Nil(t, serverErr, "test[%d]", i)
+			assert.Equal(t, HandshakeResult{PeerHostname: "server", Username: test.username, KeepAlive: true}, result, "test[%d]", i)
+		} else {
+			assert.ErrorIs(t, err, ErrAuthRejected, "test[%d]", i)
+			assert.ErrorContains(t, err, test.reason, "test[%d]", i)
+			assert.ErrorIs(t, serverErr, ErrAuthRejected, "test[%d]", i)
+		}
+
+		clientConn.Close()
+		serverConn.Close()
+	}
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	runHandshake := func(serverOptions ServerHandshakeOptions, clientOptions ClientHandshakeOptions) error {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+		go func() {
+			_, _ = DoServerHandshake(context.Background(), serverConn, serverOptions)
+		}()
+		_, err := DoClientHandshake(context.Background(), clientConn, clientOptions)
+		return err
+	}
+	serverOptions := ServerHandshakeOptions{
+		SharedKey:      "key",
+		Users:          nil,
+		Auth:           nil,
+		ServerHostname: "server",
+	}
+	clientOptions := ClientHandshakeOptions{
+		SharedKey:              "key",
+		Username:               "",
+		Password:               "",
+		ClientHostname:         "client",
+		SaltSource:             zeroReader{},
+		ExpectedServerHostname: "server",
+	}
+	assert.Nil(t, runHandshake(serverOptions, clientOptions))
+
+	wrongKeyOptions := clientOptions
+	wrongKeyOptions.SharedKey = "wrong"
+	assert.ErrorIs(t, runHandshake(serverOptions, wrongKeyOptions), ErrAuthRejected)
+	assert.ErrorContains(t, runHandshake(serverOptions, wrongKeyOptions), "shared_key mismatch")
+
+	// invalid digest in PONG
+	{
+		clientConn, serverConn := net.Pipe()
+		go func() {
+			helo, _ := msgpack.Marshal(Helo{Type: "HELO", Options: HeloOptions{Nonce: []byte{1}, Auth: nil, KeepAlive: true}})
+			_, _ = serverConn.Write(helo)
+			_ = msgpack.NewDecoder(serverConn).Decode(&Ping{})
+			pong, _ := msgpack.Marshal(Pong{Type: "PONG", AuthResult: true, Reason: "", ServerHostname: "server", SharedKeyHexdigest: "bad"})
+			_, _ = serverConn.Write(pong)
+		}()
+		_, err := DoClientHandshake(context.Background(), clientConn, clientOptions)
+		assert.ErrorIs(t, err, ErrDigestMismatch)
+		clientConn.Close()
+		serverConn.Close()
+	}
+
+	wrongServerOptions := clientOptions
+	wrongServerOptions.ExpectedServerHostname = "another"
+	assert.ErrorIs(t, runHandshake(serverOptions, wrongServerOptions), ErrHostnameMismatch)
+
+	shortSaltOptions := clientOptions
+	shortSaltOptions.SaltSource = bytes.NewReader(nil)
+	assert.ErrorContains(t, runHandshake(serverOptions, shortSaltOptions), "salt: EOF")
+
+	// unexpected message
+	{
+		clientConn, serverConn := net.Pipe()
+		go func() {
+			pong, _ := msgpack.Marshal(Pong{Type: "PONG", AuthResult: true, Reason: "", ServerHostname: "", SharedKeyHexdigest: ""})
+			_, _ = serverConn.Write(pong)
+		}()
+		_, err := DoClientHandshake(context.Background(), clientConn, clientOptions)
+		assert.ErrorIs(t, err, ErrUnexpectedMessage)
+		clientConn.Close()
+		serverConn.Close()
+	}
+
+	// timeout and cancellation with silent server
+	{
+		clientConn, serverConn := net.Pipe()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := DoClientHandshake(ctx, clientConn, clientOptions)
+		cancel()
+		assert.ErrorIs(t, err, ErrTimeout)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		_, err = DoServerHandshake(ctx, serverConn, serverOptions)
+		assert.ErrorIs(t, err, context.Canceled)
+		clientConn.Close()
+		serverConn.Close()
+	}
+}
+
+func TestHandshakeTenants(t *testing.T) {
+	serverOptions := ServerHandshakeOptions{
+		SharedKey:      "",
+		SharedKeys:     map[string]string{"fleet-a": "key-a", "fleet-b": "key-b"},
+		Users:          nil,
+		Auth:           acceptAll,
+		ServerHostname: "server",
+	}
+	for _, sharedKey := range []string{"key-a", "key-b", "", "key-c"} {
+		clientConn, serverConn := net.Pipe()
+		serverResult := make(chan HandshakeResult, 1)
+		serverErr := make(chan error, 1)
+		go func() {
+			result, err := DoServerHandshake(context.Background(), serverConn, serverOptions)
+			serverResult <- result
+			serverErr <- err
+		}()
+		_, err := DoClientHandshake(context.Background(), clientConn, ClientHandshakeOptions{
+			SharedKey:              sharedKey,
+			Username:               "",
+			Password:               "",
+			ClientHostname:         "client",
+			SaltSource:             nil,
+			ExpectedServerHostname: "server",
+		})
+		switch sharedKey {
+		case "key-a", "key-b":
+			assert.Nil(t, err, sharedKey)
+			assert.Nil(t, <-serverErr, sharedKey)
+			assert.Equal(t, strings.Replace(sharedKey, "key", "fleet", 1), (<-serverResult).Tenant, sharedKey)
+		default:
+			assert.ErrorIs(t, err, ErrAuthRejected, sharedKey)
+			assert.ErrorIs(t, <-serverErr, ErrDigestMismatch, sharedKey)
+			assert.Equal(t, "", (<-serverResult).Tenant, sharedKey)
+		}
+		clientConn.Close()
+		serverConn.Close()
+	}
+}
+
+func TestHandshakeEncoding(t *testing.T) {
+	heloBin, err := msgpack.Marshal(Helo{Type: "HELO", Options: HeloOptions{Nonce: []byte{1, 2}, Auth: nil, KeepAlive: true}})
+	assert.Nil(t, err)
+	// nonce as bin and auth as empty str
+	assert.Equal(t, "92a448454c4f83a56e6f6e6365c4020102a461757468a0a96b656570616c697665c3", hex.EncodeToString(heloBin))
+
+	// legacy str nonce and auth salt are accepted
+	legacyBin, err := msgpack.Marshal([]interface{}{"HELO", map[string]interface{}{"nonce": "12345", "auth": "678", "keepalive": true}})
+	assert.Nil(t, err)
+	helo := Helo{}
+	assert.Nil(t, msgpack.Unmarshal(legacyBin, &helo))
+	assert.Equal(t, []byte("12345"), helo.Options.Nonce)
+	assert.Equal(t, []byte("678"), helo.Options.Auth)
+	assert.True(t, helo.Options.KeepAlive)
+
+	// client handshake with legacy server
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		_, _ = serverConn.Write(legacyBin)
+		ping := Ping{}
+		assert.Nil(t, msgpack.NewDecoder(serverConn).Decode(&ping))
+		assert.Equal(t, makePasswordHexdigest([]byte("678"), "alice", "wonderland"), ping.Password)
+		pong, _ := msgpack.Marshal(Pong{
+			Type:               "PONG",
+			AuthResult:         true,
+			Reason:             "",
+			ServerHostname:     "legacy",
+			SharedKeyHexdigest: sha512ToHexdigest(string(ping.SharedKeySalt) + "legacy" + "12345" + "key"),
+		})
+		_, _ = serverConn.Write(pong)
+	}()
+	result, err := DoClientHandshake(context.Background(), clientConn, ClientHandshakeOptions{
+		SharedKey:              "key",
+		Username:               "alice",
+		Password:               "wonderland",
+		ClientHostname:         "",
+		SaltSource:             nil,
+		ExpectedServerHostname: "legacy",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "legacy", result.PeerHostname)
+	clientConn.Close()
+	serverConn.Close()
+}
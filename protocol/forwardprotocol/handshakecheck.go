@@ -0,0 +1,299 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v4"
+	"github.com/vmihailenco/msgpack/v4/codes"
+)
+
+// HandshakeTranscript contains the captured HELO, PING and PONG of a handshake to be validated against the spec
+type HandshakeTranscript struct {
+	Helo         []byte // Msgpack-encoded HELO from server
+	Ping         []byte // Msgpack-encoded PING from client
+	Pong         []byte // Msgpack-encoded PONG from server
+	SharedKey    string // Shared key to verify digests in PING and PONG, or empty to skip the verification
+	MessageCount int    // Number of messages sent after handshake on the same connection, or zero if unknown
+}
+
+// HandshakeIssue is a deviation from the spec found in a handshake transcript
+type HandshakeIssue struct {
+	Message string // "HELO", "PING" or "PONG"
+	Field   string // Field name in the spec, e.g. "nonce", or empty for the message itself
+	Problem string
+}
+
+func (issue HandshakeIssue) String() string {
+	if issue.Field == "" {
+		return issue.Message + ": " + issue.Problem
+	}
+	return issue.Message + "." + issue.Field + ": " + issue.Problem
+}
+
+// transcriptReporter records an issue found in handshake transcript
+type transcriptReporter func(message, field, format string, args ...interface{})
+
+// transcriptValue is a decoded value in handshake messages with its msgpack type preserved
+type transcriptValue struct {
+	kind  string // "str", "bin", "bool", "map", etc
+	value interface{}
+	items map[string]transcriptValue // Decoded items if kind is "map"
+}
+
+// Validate checks the transcript and returns issues found, or nil if it conforms to the spec
+//
+// Checks include message types and field counts, msgpack types of fields (e.g. nonce sent as str instead of bin),
+// missing HELO options, keepalive semantics, format of digests, consistency between auth fields, and the shared key
+// digests if SharedKey is set. Decoding in this package accepts some of the deviations reported here.
+func (t HandshakeTranscript) Validate() []HandshakeIssue {
+	var issues []HandshakeIssue
+	report := transcriptReporter(func(message, field, format string, args ...interface{}) {
+		issues = append(issues, HandshakeIssue{
+			Message: message,
+			Field:   field,
+			Problem: fmt.Sprintf(format, args...),
+		})
+	})
+
+	helo := decodeTranscriptMessage("HELO", t.Helo, 2, report)
+	ping := decodeTranscriptMessage("PING", t.Ping, 6, report)
+	pong := decodeTranscriptMessage("PONG", t.Pong, 5, report)
+
+	// HELO: ["HELO", {nonce: bin, auth: bin or "", keepalive: bool}]
+	var nonce, authSalt []byte
+	authSaltKnown := false
+	if helo != nil {
+		if options := checkTranscriptField(helo[1], "HELO", "options", "map", report); options != nil {
+			if value, found := options.items["nonce"]; !found {
+				report("HELO", "nonce", "missing")
+			} else {
+				nonce, _ = checkTranscriptBinary(value, "HELO", "nonce", report)
+				if nonce != nil && len(nonce) == 0 {
+					report("HELO", "nonce", "empty")
+				}
+			}
+			if value, found := options.items["auth"]; !found {
+				report("HELO", "auth", "missing")
+			} else {
+				authSalt, authSaltKnown = checkTranscriptBinary(value, "HELO", "auth", report)
+			}
+			if value, found := options.items["keepalive"]; !found {
+				report("HELO", "keepalive", "missing")
+			} else if keepalive := checkTranscriptField(value, "HELO", "keepalive", "bool", report); keepalive != nil {
+				if !keepalive.value.(bool) && t.MessageCount > 1 {
+					report("HELO", "keepalive", "false but %d messages are sent on the connection", t.MessageCount)
+				}
+			}
+		}
+	}
+
+	// PING: ["PING", client_hostname, shared_key_salt, shared_key_hexdigest, username, password]
+	var clientHostname, sharedKeySalt []byte
+	if ping != nil {
+		clientHostname = checkTranscriptString(ping[1], "PING", "client_hostname", report)
+		sharedKeySalt, _ = checkTranscriptBinary(ping[2], "PING", "shared_key_salt", report)
+		digest := checkTranscriptString(ping[3], "PING", "shared_key_hexdigest", report)
+		checkTranscriptHexdigest(digest, "PING", "shared_key_hexdigest", report)
+		username := checkTranscriptString(ping[4], "PING", "username", report)
+		password := checkTranscriptString(ping[5], "PING", "password", report)
+		if authSaltKnown {
+			if len(authSalt) == 0 {
+				if len(username) > 0 || len(password) > 0 {
+					report("PING", "password", "should be empty with username if auth is not required in HELO")
+				}
+			} else {
+				checkTranscriptHexdigest(password, "PING", "password", report)
+			}
+		}
+		if t.SharedKey != "" && digest != nil && nonce != nil && sharedKeySalt != nil {
+			expected := makeSharedKeyHexdigest(sharedKeySalt, string(clientHostname), nonce, t.SharedKey)
+			if string(digest) != expected {
+				report("PING", "shared_key_hexdigest", "mismatch with the shared key")
+			}
+		}
+	}
+
+	// PONG: ["PONG", auth_result, reason, server_hostname, shared_key_hexdigest]
+	if pong != nil {
+		var authResult bool
+		if result := checkTranscriptField(pong[1], "PONG", "auth_result", "bool", report); result != nil {
+			authResult = result.value.(bool)
+		}
+		reason := checkTranscriptString(pong[2], "PONG", "reason", report)
+		serverHostname := checkTranscriptString(pong[3], "PONG", "server_hostname", report)
+		digest := checkTranscriptString(pong[4], "PONG", "shared_key_hexdigest", report)
+		switch {
+		case authResult && len(reason) > 0:
+			report("PONG", "reason", "should be empty if auth_result is true")
+		case !authResult && reason != nil && len(reason) == 0:
+			report("PONG", "reason", "should not be empty if auth_result is false")
+		}
+		// digest may be empty in failed PONG from Fluentd
+		if authResult {
+			checkTranscriptHexdigest(digest, "PONG", "shared_key_hexdigest", report)
+			if t.SharedKey != "" && digest != nil && nonce != nil && sharedKeySalt != nil {
+				expected := makeSharedKeyHexdigest(sharedKeySalt, string(serverHostname), nonce, t.SharedKey)
+				if string(digest) != expected {
+					report("PONG", "shared_key_hexdigest", "mismatch with the shared key")
+				}
+			}
+		}
+	}
+
+	return issues
+}
+
+// decodeTranscriptMessage decodes a handshake message as array of values and checks its type and field count
+//
+// Returns nil if the message cannot be checked further
+func decodeTranscriptMessage(name string, data []byte, fieldCount int, report transcriptReporter) []transcriptValue {
+	if len(data) == 0 {
+		report(name, "", "missing")
+		return nil
+	}
+	reader := bytes.NewReader(data)
+	decoder := msgpack.NewDecoder(reader)
+	value, err := decodeTranscriptValue(decoder)
+	if err != nil {
+		report(name, "", "malformed: %s", err.Error())
+		return nil
+	}
+	if reader.Len() > 0 {
+		report(name, "", "trailing %d bytes", reader.Len())
+	}
+	fields, ok := value.value.([]transcriptValue)
+	if !ok {
+		report(name, "", "should be array, got %s", value.kind)
+		return nil
+	}
+	if len(fields) == 0 {
+		report(name, "", "empty array")
+		return nil
+	}
+	if typ := checkTranscriptString(fields[0], name, "type", report); typ != nil && string(typ) != name {
+		report(name, "type", "should be %q, got %q", name, string(typ))
+	}
+	if len(fields) != fieldCount {
+		report(name, "", "field count: %d (should be %d)", len(fields), fieldCount)
+		if len(fields) < fieldCount {
+			return nil
+		}
+	}
+	return fields
+}
+
+func decodeTranscriptValue(decoder *msgpack.Decoder) (transcriptValue, error) {
+	code, err := decoder.PeekCode()
+	if err != nil {
+		return transcriptValue{}, err
+	}
+	switch {
+	case codes.IsFixedMap(code) || code == codes.Map16 || code == codes.Map32:
+		n, err := decoder.DecodeMapLen()
+		if err != nil {
+			return transcriptValue{}, err
+		}
+		items := make(map[string]transcriptValue, minInt(n, maxPreallocatedItems))
+		for i := 0; i < n; i++ {
+			key, err := decoder.DecodeString()
+			if err != nil {
+				return transcriptValue{}, err
+			}
+			item, err := decodeTranscriptValue(decoder)
+			if err != nil {
+				return transcriptValue{}, err
+			}
+			items[key] = item
+		}
+		return transcriptValue{kind: "map", value: nil, items: items}, nil
+	case codes.IsFixedArray(code) || code == codes.Array16 || code == codes.Array32:
+		n, err := decoder.DecodeArrayLen()
+		if err != nil {
+			return transcriptValue{}, err
+		}
+		list := make([]transcriptValue, 0, minInt(n, maxPreallocatedItems))
+		for i := 0; i < n; i++ {
+			item, err := decodeTranscriptValue(decoder)
+			if err != nil {
+				return transcriptValue{}, err
+			}
+			list = append(list, item)
+		}
+		return transcriptValue{kind: "array", value: list, items: nil}, nil
+	}
+	value, err := decoder.DecodeInterface()
+	if err != nil {
+		return transcriptValue{}, err
+	}
+	var kind string
+	switch {
+	case codes.IsString(code):
+		kind = "str"
+	case codes.IsBin(code):
+		kind = "bin"
+	case code == codes.Nil:
+		kind = "nil"
+	case code == codes.True || code == codes.False:
+		kind = "bool"
+	case code == codes.Float || code == codes.Double:
+		kind = "float"
+	case codes.IsExt(code):
+		kind = "ext"
+	default:
+		kind = "int"
+	}
+	return transcriptValue{kind: kind, value: value, items: nil}, nil
+}
+
+// checkTranscriptField returns the value if it's of the expected kind, or reports an issue and returns nil
+func checkTranscriptField(value transcriptValue, message, field, kind string, report transcriptReporter) *transcriptValue {
+	if value.kind != kind {
+		report(message, field, "should be %s, got %s", kind, value.kind)
+		return nil
+	}
+	return &value
+}
+
+// checkTranscriptString returns the non-nil content of a str field, or reports an issue and returns nil
+func checkTranscriptString(value transcriptValue, message, field string, report transcriptReporter) []byte {
+	if checkTranscriptField(value, message, field, "str", report) == nil {
+		return nil
+	}
+	return []byte(value.value.(string))
+}
+
+// checkTranscriptBinary returns the content of a bin field, or of str with an issue reported
+//
+// Empty str is accepted without issue since the spec uses it for "auth" if not required.
+// Returns (content, known?)
+func checkTranscriptBinary(value transcriptValue, message, field string, report transcriptReporter) ([]byte, bool) {
+	switch value.kind {
+	case "bin":
+		return append([]byte{}, value.value.([]byte)...), true
+	case "str":
+		s := value.value.(string)
+		if s != "" || field != "auth" {
+			report(message, field, "should be bin, got str")
+		}
+		return []byte(s), true
+	default:
+		report(message, field, "should be bin, got %s", value.kind)
+		return nil, false
+	}
+}
+
+// checkTranscriptHexdigest reports an issue if the given digest isn't hex-encoded SHA512 in lower case
+func checkTranscriptHexdigest(digest []byte, message, field string, report transcriptReporter) {
+	if digest == nil {
+		return
+	}
+	if len(digest) != 128 {
+		report(message, field, "should be hex SHA512 of 128 chars, got %d chars", len(digest))
+		return
+	}
+	if _, err := hex.DecodeString(string(digest)); err != nil || !bytes.Equal(bytes.ToLower(digest), digest) {
+		report(message, field, "should be hex SHA512 in lower case")
+	}
+}
@@ -0,0 +1,110 @@
+package forwardprotocol
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// recordingConn records each write as one handshake message
+type recordingConn struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte{}, b...))
+	return c.Conn.Write(b)
+}
+
+func TestHandshakeTranscriptValidate(t *testing.T) {
+	clientPipe, serverPipe := net.Pipe()
+	clientConn := &recordingConn{Conn: clientPipe, writes: nil}
+	serverConn := &recordingConn{Conn: serverPipe, writes: nil}
+	serverDone := make(chan struct{})
+	go func() {
+		_, err := DoServerHandshake(context.Background(), serverConn, ServerHandshakeOptions{
+			SharedKey:      "key",
+			Users:          map[string]string{"alice": "wonderland"},
+			Auth:           acceptAll,
+			ServerHostname: "",
+		})
+		assert.Nil(t, err)
+		serverConn.Close()
+		close(serverDone)
+	}()
+	_, err := DoClientHandshake(context.Background(), clientConn, ClientHandshakeOptions{
+		SharedKey:              "key",
+		Username:               "alice",
+		Password:               "wonderland",
+		ClientHostname:         "",
+		SaltSource:             nil,
+		ExpectedServerHostname: "",
+	})
+	assert.Nil(t, err)
+	clientConn.Close()
+	<-serverDone
+
+	transcript := HandshakeTranscript{
+		Helo:         serverConn.writes[0],
+		Ping:         clientConn.writes[0],
+		Pong:         serverConn.writes[1],
+		SharedKey:    "key",
+		MessageCount: 2,
+	}
+	assert.Nil(t, transcript.Validate())
+
+	transcript.SharedKey = "wrong"
+	assert.Equal(t, []string{
+		"PING.shared_key_hexdigest: mismatch with the shared key",
+		"PONG.shared_key_hexdigest: mismatch with the shared key",
+	}, issuesToStrings(transcript.Validate()))
+
+	// legacy encoding with decimal string nonce and salt
+	legacyHelo, _ := msgpack.Marshal([]interface{}{"HELO", map[string]interface{}{"nonce": "12345", "keepalive": "false"}})
+	legacyPing, _ := msgpack.Marshal([]interface{}{"PING", "client", "678", "abc", "alice", ""})
+	legacyPong, _ := msgpack.Marshal([]interface{}{"PONG", false, "", "server"})
+	legacyTranscript := HandshakeTranscript{
+		Helo:         legacyHelo,
+		Ping:         legacyPing,
+		Pong:         legacyPong,
+		SharedKey:    "",
+		MessageCount: 2,
+	}
+	assert.Equal(t, []string{
+		"PONG: field count: 4 (should be 5)",
+		"HELO.nonce: should be bin, got str",
+		"HELO.auth: missing",
+		"HELO.keepalive: should be bool, got str",
+		"PING.shared_key_salt: should be bin, got str",
+		"PING.shared_key_hexdigest: should be hex SHA512 of 128 chars, got 3 chars",
+	}, issuesToStrings(legacyTranscript.Validate()))
+
+	keepAliveHelo, _ := msgpack.Marshal(Helo{Type: "HELO", Options: HeloOptions{Nonce: []byte{1}, Auth: nil, KeepAlive: false}})
+	keepAlivePong, _ := msgpack.Marshal([]interface{}{"PONG", false, "", "server", ""})
+	keepAliveTranscript := HandshakeTranscript{
+		Helo:         keepAliveHelo,
+		Ping:         legacyPing,
+		Pong:         keepAlivePong,
+		SharedKey:    "",
+		MessageCount: 2,
+	}
+	assert.Equal(t, []string{
+		"HELO.keepalive: false but 2 messages are sent on the connection",
+		"PING.shared_key_salt: should be bin, got str",
+		"PING.shared_key_hexdigest: should be hex SHA512 of 128 chars, got 3 chars",
+		"PING.password: should be empty with username if auth is not required in HELO",
+		"PONG.reason: should not be empty if auth_result is false",
+	}, issuesToStrings(keepAliveTranscript.Validate()))
+}
+
+func issuesToStrings(issues []HandshakeIssue) []string {
+	var list []string
+	for _, issue := range issues {
+		list = append(list, issue.String())
+	}
+	return list
+}
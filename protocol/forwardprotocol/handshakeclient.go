@@ -2,71 +2,110 @@ package forwardprotocol
 
 import (
 	"bufio"
-	"math/rand"
+	"context"
+	"fmt"
+	"io"
 	"net"
 	"os"
-	"strconv"
-	"time"
 
 	"github.com/vmihailenco/msgpack/v4"
 )
 
+// ClientHandshakeOptions contains options for client-side handshake
+type ClientHandshakeOptions struct {
+	SharedKey              string    // Shared key to compute and verify digests
+	Username               string    // Username for user authentication if required by server
+	Password               string    // Password for user authentication if required by server
+	ClientHostname         string    // Hostname sent in PING, empty to use os.Hostname()
+	SaltSource             io.Reader // Source of shared key salt in PING, nil to use crypto/rand
+	ExpectedServerHostname string    // Server hostname expected in PONG, empty to accept any
+}
+
 // DoClientHandshake performs client-side handshake on the given forward protocol connection.
 //
-// Returns (success?, failure reason, network error)
-func DoClientHandshake(conn net.Conn, sharedKey string, timeout time.Duration) (bool, string, error) {
-	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
-		return false, "failed to set timeout: " + err.Error(), nil
+// The username and password are only sent if server requests user authentication in HELO. The deadline of ctx is
+// applied to the connection, and pending I/O is interrupted if ctx is cancelled.
+//
+// Errors wrap ErrAuthRejected (with the reason from server), ErrDigestMismatch, ErrHostnameMismatch,
+// ErrUnexpectedMessage, ErrTimeout, context.Canceled or network errors.
+func DoClientHandshake(ctx context.Context, conn net.Conn, options ClientHandshakeOptions) (HandshakeResult, error) {
+	result := HandshakeResult{
+		PeerHostname: "",
+		Username:     "",
+		Tenant:       "",
+		KeepAlive:    false,
 	}
+	hostname := options.ClientHostname
+	if hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return result, fmt.Errorf("hostname: %w", err)
+		}
+		hostname = h
+	}
+	salt, saltErr := newRandomBytes(options.SaltSource, 16)
+	if saltErr != nil {
+		return result, fmt.Errorf("salt: %w", saltErr)
+	}
+
+	end, beginErr := beginHandshake(ctx, conn)
+	if beginErr != nil {
+		return result, beginErr
+	}
+	defer end()
 	decoder := msgpack.NewDecoder(conn)
 	bwriter := bufio.NewWriterSize(conn, 1024)
 	encoder := msgpack.NewEncoder(bwriter)
 
 	// read HELO
 	helo := Helo{}
-	if err := decoder.Decode(&helo); err != nil {
-		return false, "", err
+	if err := readHandshakeMessage(ctx, decoder, "HELO", &helo); err != nil {
+		return result, err
 	}
 	if helo.Type != "HELO" {
-		return false, "server sent garbage HELO: " + helo.Type, nil
+		return result, fmt.Errorf("%w: got '%s', wanted HELO", ErrUnexpectedMessage, helo.Type)
 	}
+	result.KeepAlive = helo.Options.KeepAlive
 
 	// send PING
-	salt := strconv.Itoa(rand.Int())
-	hostname, err := os.Hostname()
-	if err != nil {
-		panic(err)
-	}
 	ping := Ping{
 		Type:               "PING",
 		ClientHostname:     hostname,
 		SharedKeySalt:      salt,
-		SharedKeyHexdigest: sha512ToHexdigest(salt + hostname + helo.Options.Nonce + sharedKey),
+		SharedKeyHexdigest: makeSharedKeyHexdigest(salt, hostname, helo.Options.Nonce, options.SharedKey),
 		Username:           "",
 		Password:           "",
 	}
+	if len(helo.Options.Auth) > 0 {
+		ping.Username = options.Username
+		ping.Password = makePasswordHexdigest(helo.Options.Auth, options.Username, options.Password)
+		result.Username = options.Username
+	}
 	if err := encoder.Encode(&ping); err != nil {
-		return false, "", err
+		return result, wrapHandshakeIOError(ctx, "send PING", err)
 	}
 	if err := bwriter.Flush(); err != nil {
-		return false, "", err
+		return result, wrapHandshakeIOError(ctx, "send PING", err)
 	}
 
 	// read PONG
 	pong := Pong{}
-	if err := decoder.Decode(&pong); err != nil {
-		return false, "", err
+	if err := readHandshakeMessage(ctx, decoder, "PONG", &pong); err != nil {
+		return result, err
 	}
 	if pong.Type != "PONG" {
-		return false, "server returned garbage PONG: " + pong.Type, nil
+		return result, fmt.Errorf("%w: got '%s', wanted PONG", ErrUnexpectedMessage, pong.Type)
 	}
-	serverDigest := sha512ToHexdigest(salt + pong.ServerHostname + helo.Options.Nonce + sharedKey)
+	result.PeerHostname = pong.ServerHostname
+	if !pong.AuthResult {
+		return result, fmt.Errorf("%w: %s", ErrAuthRejected, pong.Reason)
+	}
+	serverDigest := makeSharedKeyHexdigest(salt, pong.ServerHostname, helo.Options.Nonce, options.SharedKey)
 	if serverDigest != pong.SharedKeyHexdigest {
-		return false, "server returned invalid digest, check shared key", nil
+		return result, fmt.Errorf("%w: server returned invalid digest, check shared key", ErrDigestMismatch)
 	}
-	if pong.AuthResult {
-		return true, "", nil
+	if options.ExpectedServerHostname != "" && pong.ServerHostname != options.ExpectedServerHostname {
+		return result, fmt.Errorf("%w: got '%s', wanted '%s'", ErrHostnameMismatch, pong.ServerHostname, options.ExpectedServerHostname)
 	}
-
-	return false, pong.Reason, nil
+	return result, nil
 }
@@ -2,12 +2,10 @@ package forwardprotocol
 
 import (
 	"bufio"
-	"errors"
-	"math/rand"
+	"context"
+	"fmt"
 	"net"
 	"os"
-	"strconv"
-	"time"
 
 	"github.com/vmihailenco/msgpack/v4"
 )
@@ -17,62 +15,167 @@ import (
 // Returns (success?, reason)
 type AuthCallback func(hostname, username, password string) (bool, string)
 
+// ServerHandshakeOptions contains options for server-side handshake
+//
+// The client must use SharedKey, or one of SharedKeys if not empty. SharedKey is not accepted if it's empty and
+// SharedKeys isn't.
+type ServerHandshakeOptions struct {
+	SharedKey      string            // Shared key to verify and compute digests
+	SharedKeys     map[string]string // Tenant name to unique shared key, accepted in addition to SharedKey
+	Users          map[string]string // Username to password, empty if user authentication isn't required
+	Auth           AuthCallback      // Callback to authenticate client after users check, nil to accept all
+	ServerHostname string            // Hostname sent in PONG, empty to use os.Hostname()
+	ClientRules    []ClientRule      // Rules to restrict clients, empty to accept any
+	AllowAnonymous bool              // Accept clients matching no ClientRules, same as allow_anonymous_source in Fluentd
+}
+
 // DoServerHandshake performs server-side handshake on the given forward protocol connection.
 //
-// Returns (success?, network error)
-func DoServerHandshake(conn net.Conn, sharedKey string, timeout time.Duration, auth AuthCallback) (bool, error) {
-	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
-		return false, err
+// The client address and hostname in PING are checked against ClientRules first, and then the shared key digest in PING
+// is verified to find the matching tenant if any. If Users is not empty, client must provide a matching username and
+// password. Auth callback is only invoked for clients that passed all the checks. Denials are sent as reason in PONG.
+//
+// The deadline of ctx is applied to the connection, and pending I/O is interrupted if ctx is cancelled.
+//
+// Errors wrap ErrDigestMismatch or ErrAuthRejected (after PONG is sent), ErrUnexpectedMessage, ErrTimeout, context.Canceled or network
+// errors.
+func DoServerHandshake(ctx context.Context, conn net.Conn, options ServerHandshakeOptions) (HandshakeResult, error) {
+	result := HandshakeResult{
+		PeerHostname: "",
+		Username:     "",
+		Tenant:       "",
+		KeepAlive:    true,
+	}
+	hostname := options.ServerHostname
+	if hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return result, fmt.Errorf("hostname: %w", err)
+		}
+		hostname = h
 	}
+	nonce, nonceErr := newRandomBytes(nil, 16)
+	if nonceErr != nil {
+		return result, fmt.Errorf("nonce: %w", nonceErr)
+	}
+	var authSalt []byte
+	if len(options.Users) > 0 {
+		salt, err := newRandomBytes(nil, 16)
+		if err != nil {
+			return result, fmt.Errorf("auth salt: %w", err)
+		}
+		authSalt = salt
+	}
+
+	end, beginErr := beginHandshake(ctx, conn)
+	if beginErr != nil {
+		return result, beginErr
+	}
+	defer end()
 	decoder := msgpack.NewDecoder(conn)
 	bwriter := bufio.NewWriterSize(conn, 1024)
 	encoder := msgpack.NewEncoder(bwriter)
 
 	// send HELO
-	nonce := strconv.Itoa(rand.Int())
 	helo := Helo{
 		Type: "HELO",
 		Options: HeloOptions{
 			Nonce:     nonce,
-			Auth:      "",
-			KeepAlive: true,
+			Auth:      authSalt,
+			KeepAlive: result.KeepAlive,
 		},
 	}
 	if err := encoder.Encode(&helo); err != nil {
-		return false, err
+		return result, wrapHandshakeIOError(ctx, "send HELO", err)
 	}
 	if err := bwriter.Flush(); err != nil {
-		return false, err
+		return result, wrapHandshakeIOError(ctx, "send HELO", err)
 	}
 
 	// read PING
 	ping := Ping{}
-	if err := decoder.Decode(&ping); err != nil {
-		return false, err
+	if err := readHandshakeMessage(ctx, decoder, "PING", &ping); err != nil {
+		return result, err
 	}
 	if ping.Type != "PING" {
-		return false, errors.New("client sent garbage PING: " + ping.Type)
+		return result, fmt.Errorf("%w: got '%s', wanted PING", ErrUnexpectedMessage, ping.Type)
 	}
-	result, reason := auth(ping.ClientHostname, ping.Username, ping.Password)
-
-	// send PONG
-	hostname, err := os.Hostname()
-	if err != nil {
-		panic(err)
+	result.PeerHostname = ping.ClientHostname
+	result.Username = ping.Username
+	rule := findClientRule(options.ClientRules, conn.RemoteAddr(), ping.ClientHostname)
+	anonymous := len(options.ClientRules) > 0 && rule == nil
+	tenant, sharedKey, keyFound := "", "", false
+	if rule != nil && rule.SharedKey != "" {
+		sharedKey = rule.SharedKey
+		keyFound = makeSharedKeyHexdigest(ping.SharedKeySalt, ping.ClientHostname, nonce, sharedKey) == ping.SharedKeyHexdigest
+	} else {
+		tenant, sharedKey, keyFound = findSharedKey(options, ping, nonce)
+	}
+	result.Tenant = tenant
+	authResult, reason := true, ""
+	switch {
+	case anonymous && !options.AllowAnonymous:
+		authResult, reason = false, fmt.Sprintf("anonymous source host '%s' denied", conn.RemoteAddr())
+	case !keyFound:
+		authResult, reason = false, "shared_key mismatch"
+	case len(options.Users) > 0 && !verifyUserPassword(options.Users, authSalt, ping.Username, ping.Password):
+		authResult, reason = false, "username/password mismatch"
+	case len(options.Users) > 0 && rule != nil && !rule.allowUser(ping.Username):
+		authResult, reason = false, "username/password mismatch"
+	case options.Auth != nil:
+		authResult, reason = options.Auth(ping.ClientHostname, ping.Username, ping.Password)
 	}
+
+	// send PONG, without hostname and digest on failure same as Fluentd
 	pong := Pong{
 		Type:               "PONG",
-		AuthResult:         result,
+		AuthResult:         authResult,
 		Reason:             reason,
-		ServerHostname:     hostname,
-		SharedKeyHexdigest: sha512ToHexdigest(ping.SharedKeySalt + hostname + nonce + sharedKey),
+		ServerHostname:     "",
+		SharedKeyHexdigest: "",
+	}
+	if authResult {
+		pong.ServerHostname = hostname
+		pong.SharedKeyHexdigest = makeSharedKeyHexdigest(ping.SharedKeySalt, hostname, nonce, sharedKey)
 	}
 	if err := encoder.Encode(&pong); err != nil {
-		return false, err
+		return result, wrapHandshakeIOError(ctx, "send PONG", err)
 	}
 	if err := bwriter.Flush(); err != nil {
-		return false, err
+		return result, wrapHandshakeIOError(ctx, "send PONG", err)
 	}
 
+	if !keyFound && !(anonymous && !options.AllowAnonymous) {
+		return result, fmt.Errorf("%w: client '%s' matches no shared key", ErrDigestMismatch, ping.ClientHostname)
+	}
+	if !authResult {
+		return result, fmt.Errorf("%w: %s", ErrAuthRejected, reason)
+	}
 	return result, nil
 }
+
+// findSharedKey finds the shared key used by client in PING
+//
+// Returns (tenant, shared key, found?)
+func findSharedKey(options ServerHandshakeOptions, ping Ping, nonce []byte) (string, string, bool) {
+	if len(options.SharedKey) > 0 || len(options.SharedKeys) == 0 {
+		if makeSharedKeyHexdigest(ping.SharedKeySalt, ping.ClientHostname, nonce, options.SharedKey) == ping.SharedKeyHexdigest {
+			return "", options.SharedKey, true
+		}
+	}
+	for tenant, key := range options.SharedKeys {
+		if makeSharedKeyHexdigest(ping.SharedKeySalt, ping.ClientHostname, nonce, key) == ping.SharedKeyHexdigest {
+			return tenant, key, true
+		}
+	}
+	return "", "", false
+}
+
+// verifyUserPassword checks the password digest sent by client in PING against the users table
+func verifyUserPassword(users map[string]string, authSalt []byte, username string, passwordDigest string) bool {
+	password, exists := users[username]
+	if !exists {
+		return false
+	}
+	return makePasswordHexdigest(authSalt, username, password) == passwordDigest
+}
@@ -0,0 +1,41 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+// HeartbeatPayload is the content of UDP heartbeat requests and responses, same as Fluentd's in_forward and out_forward
+var HeartbeatPayload = []byte{0}
+
+// ProbeHeartbeat sends a UDP heartbeat to the address of a forward server and waits for its response
+//
+// The address is the same as the TCP address of server, e.g. "localhost:24224"
+//
+// Returns the round-trip time, or error if no valid response is received before timeout
+func ProbeHeartbeat(address string, timeout time.Duration) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return 0, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	startTime := time.Now()
+	if err := conn.SetDeadline(startTime.Add(timeout)); err != nil {
+		return 0, fmt.Errorf("set timeout: %w", err)
+	}
+	if _, err := conn.Write(HeartbeatPayload); err != nil {
+		return 0, fmt.Errorf("write: %w", err)
+	}
+	buffer := make([]byte, 16)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return 0, fmt.Errorf("read: %w", err)
+	}
+	if !bytes.Equal(buffer[:n], HeartbeatPayload) {
+		return 0, fmt.Errorf("invalid heartbeat response: %q", buffer[:n])
+	}
+	return time.Since(startTime), nil
+}
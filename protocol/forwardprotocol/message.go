@@ -10,6 +10,9 @@ import (
 type MessageMode string
 
 const (
+	// ModeMessage serializes a single log as tag, time and record, with optional option in the end
+	ModeMessage MessageMode = "Message"
+
 	// ModeForward serializes logs as a msgpack array, the original and fluent-bit compatible format
 	ModeForward MessageMode = "Forward"
 
@@ -21,23 +24,58 @@ const (
 	ModeCompressedPackedForward MessageMode = "CompressedPackedForward"
 )
 
+// FluentSignal is the type of payload in a message from Fluent Bit, as "fluent_signal" in TransportOption
+type FluentSignal int
+
+const (
+	// FluentSignalLogs means the message contains log events, the default
+	FluentSignalLogs FluentSignal = 0
+
+	// FluentSignalMetrics means the message contains cmetrics contexts in msgpack, see package fluentbitsignal
+	FluentSignalMetrics FluentSignal = 1
+
+	// FluentSignalTraces means the message contains ctraces contexts in msgpack, see package fluentbitsignal
+	FluentSignalTraces FluentSignal = 2
+)
+
+func (s FluentSignal) String() string {
+	switch s {
+	case FluentSignalLogs:
+		return "logs"
+	case FluentSignalMetrics:
+		return "metrics"
+	case FluentSignalTraces:
+		return "traces"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
 // Message represents a request to forward a batch of log events to Fluentd
 //
-// The struct is not used directly for encoding but serves as a reference
+// The struct is decoded by custom DecodeMsgpack and should be encoded by EncodeMessage in a specific MessageMode
+//
+// Messages of metrics or traces from Fluent Bit carry the raw msgpack in Payload instead of Entries
 type Message struct {
 	_msgpack struct{}        `msgpack:",asArray"`
 	Tag      string          `msgpack:"tag"`
 	Entries  []EventEntry    `msgpack:"entries"` // Depending on MessageMode, the entries may be serialized as-is or in other formats
 	Option   TransportOption `msgpack:"option"`
+	Mode     MessageMode     `msgpack:"-"` // The mode detected in decoding, not used in encoding
+	Payload  []byte          `msgpack:"-"` // Uncompressed payload if Option.FluentSignal is metrics or traces
+	lazy     *lazyEntries    // Undecoded entries if decoded with DecodeOptions.LazyEntries
 }
 
 // EventEntry represents a single log record in forward messages
 //
-// The struct is not used directly for encoding but serves as a reference
+// The struct is encoded and decoded by custom EncodeMsgpack and DecodeMsgpack, in order to support both the classic
+// format [time, record] and Fluent Bit v2 format [[time, metadata], record]. The tags here serve as a reference.
 type EventEntry struct {
-	_msgpack struct{}               `msgpack:",asArray"`
-	Time     EventTime              `msgpack:"time"`
-	Record   map[string]interface{} `msgpack:"record"`
+	_msgpack     struct{}               `msgpack:",asArray"`
+	Time         EventTime              `msgpack:"time"`
+	Record       map[string]interface{} `msgpack:"record"`
+	Metadata     map[string]interface{} `msgpack:"-"` // Metadata in Fluent Bit v2 format, nil if absent
+	TimeEncoding TimeEncoding           `msgpack:"-"` // Representation of Time detected in decoding, not used in encoding
 }
 
 // TransportOption is the option of each transport request (last value of array)
@@ -46,11 +84,13 @@ type TransportOption struct {
 	Size       int      `msgpack:"size" json:"size"`             // The numbers of log records in this msg
 	Chunk      string   `msgpack:"chunk" json:"chunk"`           // Chunk ID, omitted if a response from server as ACK is not needed
 	Compressed string   `msgpack:"compressed" json:"compressed"` // set to ForwardCompressionFormat for "CompressedPackedForward" mode
+
+	FluentSignal FluentSignal `msgpack:"fluent_signal" json:"fluent_signal"` // Type of payload from Fluent Bit, omitted for logs
 }
 
 // Ack is the acknowledgement or response from server to client for receiving a chunk
 type Ack struct {
-	Ack string `msgpack:"ack"` // equals to ForwardTransportOption.Chunk
+	Ack string `msgpack:"ack" json:"ack"` // equals to ForwardTransportOption.Chunk
 }
 
 // CompressionFormat defines the compression format, only "gzip" is supported
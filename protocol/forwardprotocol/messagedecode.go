@@ -3,7 +3,6 @@ package forwardprotocol
 import (
 	"bytes"
 	"compress/gzip"
-	"errors"
 	"fmt"
 	"io"
 
@@ -12,81 +11,262 @@ import (
 )
 
 var _ msgpack.CustomDecoder = (*Message)(nil)
+var _ msgpack.CustomDecoder = (*EventEntry)(nil)
+
+// DecodeOptions contains options to decode messages
+//
+// Limits are disabled by zero values. Violations are returned as *LimitError.
+type DecodeOptions struct {
+	LazyEntries          bool // Keep entries undecoded in Forward and (Compressed)PackedForward modes, see Message.EntryIterator
+	MaxMessageBytes      int  // Max bytes of an encoded message, only enforced by MessageDecoder
+	MaxDecompressedBytes int  // Max bytes of packed entries or payload after decompression
+	MaxEntries           int  // Max number of entries in a message
+	MaxNestingDepth      int  // Max depth of nested maps and arrays in records and metadata, in which 1 means flat
+	MaxStringLength      int  // Max length of tag, keys and string or binary values in records and metadata
+}
 
 // DecodeMsgpack is the custom msgpack decoding implementation for Message, in order to decode Entries properly
 //
+// All modes in the spec are accepted, with or without the trailing option. The detected mode is saved in Mode.
+//
 // See MessageMode for different types of Entries encoding
 func (msg *Message) DecodeMsgpack(decoder *msgpack.Decoder) error {
-	// first is array length; should be 3
-	{
-		len, err := decoder.DecodeArrayLen()
-		if err != nil {
-			return fmt.Errorf("message's field count: %w", err)
-		}
-		if len != 3 {
-			return fmt.Errorf("message's field count: %d (should be 3)", len)
-		}
+	return msg.decode(decoder, DecodeOptions{
+		LazyEntries:          false,
+		MaxMessageBytes:      0,
+		MaxDecompressedBytes: 0,
+		MaxEntries:           0,
+		MaxNestingDepth:      0,
+		MaxStringLength:      0,
+	})
+}
+
+// DecodeMessage decodes a message by the given options, see Message.DecodeMsgpack
+//
+// Limits on entries are applied later during iteration if LazyEntries is set.
+func DecodeMessage(decoder *msgpack.Decoder, options DecodeOptions) (Message, error) {
+	var msg Message
+	err := msg.decode(decoder, options)
+	return msg, err
+}
 
+func (msg *Message) decode(decoder *msgpack.Decoder, options DecodeOptions) error {
+	msg.Entries = nil
+	msg.Payload = nil
+	msg.lazy = nil
+	// first is array length; 2-3 for Forward and PackedForward or 3-4 for Message mode
+	fieldCount, err := decoder.DecodeArrayLen()
+	if err != nil {
+		return fmt.Errorf("message's field count: %w", err)
+	}
+	if fieldCount < 2 || fieldCount > 4 {
+		return fmt.Errorf("message's field count: %d (should be 2 to 4)", fieldCount)
 	}
 	// array[0] is tag
 	{
-		tag, err := decoder.DecodeString()
+		tag, err := decodeString(decoder, options)
 		if err != nil {
 			return fmt.Errorf("message's tag: %w", err)
 		}
 		msg.Tag = tag
 	}
-	// array[1] is array of entries or binary
+	// array[1] is array of entries, binary or time of the single entry
 	var maybeEntriesBinary []byte
+	optionIndex := 2
 	{
 		code, cerr := decoder.PeekCode()
 		if cerr != nil {
 			return fmt.Errorf("message's entries code: %w", cerr)
 		}
-		if !codes.IsBin(code) {
-			if err := decoder.Decode(&msg.Entries); err != nil {
+		switch {
+		case codes.IsFixedArray(code) || code == codes.Array16 || code == codes.Array32:
+			msg.Mode = ModeForward
+			if options.LazyEntries {
+				var raw rawValue
+				if err := decoder.Decode(&raw); err != nil {
+					return fmt.Errorf("message's entries as raw array of logs: %w", err)
+				}
+				msg.lazy = &lazyEntries{
+					data:       raw,
+					compressed: false,
+					isArray:    true,
+					options:    options,
+				}
+			} else if err := msg.decodeEntriesArray(decoder, options); err != nil {
 				return fmt.Errorf("message's entries as array of logs: %w", err)
 			}
-		} else if err := decoder.Decode(&maybeEntriesBinary); err != nil {
-			return fmt.Errorf("message's entries as binary: %w", err)
+		case codes.IsBin(code) || codes.IsString(code):
+			msg.Mode = ModePackedForward
+			if err := decoder.Decode(&maybeEntriesBinary); err != nil {
+				return fmt.Errorf("message's entries as binary: %w", err)
+			}
+		default:
+			msg.Mode = ModeMessage
+			if fieldCount < 3 {
+				return fmt.Errorf("message's field count: %d (should be 3 or 4 in %s mode)", fieldCount, msg.Mode)
+			}
+			entry := EventEntry{}
+			if err := decodeEventTime(decoder, &entry); err != nil {
+				return fmt.Errorf("message's time: %w", err)
+			}
+			record, err := decodeRecord(decoder, options)
+			if err != nil {
+				return fmt.Errorf("message's record: %w", err)
+			}
+			entry.Record = record
+			msg.Entries = []EventEntry{entry}
+			optionIndex = 3
+		}
+		if fieldCount > optionIndex+1 {
+			return fmt.Errorf("message's field count: %d (should be %d or %d in %s mode)", fieldCount, optionIndex, optionIndex+1, msg.Mode)
 		}
 	}
-	// array[2] is option
-	if err := decoder.Decode(&msg.Option); err != nil {
-		return fmt.Errorf("message's option map: %w", err)
+	// array[2] or array[3] is option if present
+	msg.Option = TransportOption{}
+	if fieldCount > optionIndex {
+		if err := decoder.Decode(&msg.Option); err != nil {
+			return fmt.Errorf("message's option map: %w", err)
+		}
 	}
 	// then decode bin if present
 	if maybeEntriesBinary != nil {
 		compressed := msg.Option.Compressed != ""
-		entries, err := decodePackedEntriesStream(maybeEntriesBinary, compressed, msg.Option.Size)
-		if err != nil {
+		if compressed {
+			msg.Mode = ModeCompressedPackedForward
+		}
+		if msg.Option.FluentSignal != FluentSignalLogs {
+			payload, err := readPackedStream(maybeEntriesBinary, compressed, options)
+			if err != nil {
+				return fmt.Errorf("message's %s binary (compressed=%t): %w", msg.Option.FluentSignal, compressed, err)
+			}
+			msg.Payload = payload
+			return nil
+		}
+		msg.lazy = &lazyEntries{
+			data:       maybeEntriesBinary,
+			compressed: compressed,
+			isArray:    false,
+			options:    options,
+		}
+		if options.LazyEntries {
+			return nil
+		}
+		if err := msg.LoadEntries(); err != nil {
 			return fmt.Errorf("message's entries binary (compressed=%t): %w", compressed, err)
 		}
-		msg.Entries = entries
 	}
 	return nil
 }
 
-func decodePackedEntriesStream(v []byte, compressed bool, size int) ([]EventEntry, error) {
-	var reader io.Reader = bytes.NewReader(v)
-	if compressed {
-		zreader, zerr := gzip.NewReader(reader)
-		if zerr != nil {
-			return nil, zerr
+func (msg *Message) decodeEntriesArray(decoder *msgpack.Decoder, options DecodeOptions) error {
+	count, err := decoder.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+	if err := options.checkEntries(count); err != nil {
+		return err
+	}
+	if count == -1 {
+		return nil
+	}
+	msg.Entries = make([]EventEntry, count)
+	for i := range msg.Entries {
+		if err := decodeEventEntry(decoder, &msg.Entries[i], options); err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
 		}
-		reader = zreader
-	}
-	decoder := msgpack.NewDecoder(reader)
-	list := make([]EventEntry, 0, size)
-	for {
-		var record EventEntry
-		if err := decoder.Decode(&record); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+	}
+	return nil
+}
+
+// DecodeMsgpack is the custom msgpack decoding implementation for EventEntry, in order to support Fluent Bit v2 format
+//
+// The format is detected by the first element: time for [time, record] or array for [[time, metadata], record]
+func (e *EventEntry) DecodeMsgpack(decoder *msgpack.Decoder) error {
+	return decodeEventEntry(decoder, e, DecodeOptions{
+		LazyEntries:          false,
+		MaxMessageBytes:      0,
+		MaxDecompressedBytes: 0,
+		MaxEntries:           0,
+		MaxNestingDepth:      0,
+		MaxStringLength:      0,
+	})
+}
+
+func decodeEventEntry(decoder *msgpack.Decoder, e *EventEntry, options DecodeOptions) error {
+	// first is array length; should be 2
+	{
+		len, err := decoder.DecodeArrayLen()
+		if err != nil {
+			return fmt.Errorf("event's field count: %w", err)
+		}
+		if len != 2 {
+			return fmt.Errorf("event's field count: %d (should be 2)", len)
+		}
+	}
+	// array[0] is time or [time, metadata]
+	{
+		code, cerr := decoder.PeekCode()
+		if cerr != nil {
+			return fmt.Errorf("event's time code: %w", cerr)
+		}
+		e.Metadata = nil
+		if codes.IsFixedArray(code) || code == codes.Array16 || code == codes.Array32 {
+			len, err := decoder.DecodeArrayLen()
+			if err != nil {
+				return fmt.Errorf("event's header field count: %w", err)
 			}
-			return list, err
+			if len != 2 {
+				return fmt.Errorf("event's header field count: %d (should be 2)", len)
+			}
+			if err := decodeEventTime(decoder, e); err != nil {
+				return fmt.Errorf("event's time: %w", err)
+			}
+			metadata, err := decodeRecord(decoder, options)
+			if err != nil {
+				return fmt.Errorf("event's metadata: %w", err)
+			}
+			e.Metadata = metadata
+		} else if err := decodeEventTime(decoder, e); err != nil {
+			return fmt.Errorf("event's time: %w", err)
 		}
-		list = append(list, record)
 	}
-	return list, nil
+	// array[1] is record
+	record, err := decodeRecord(decoder, options)
+	if err != nil {
+		return fmt.Errorf("event's record: %w", err)
+	}
+	e.Record = record
+	return nil
+}
+
+// decodeEventTime decodes the time of an event and saves its representation by the msgpack code in TimeEncoding
+func decodeEventTime(decoder *msgpack.Decoder, e *EventEntry) error {
+	code, err := decoder.PeekCode()
+	if err != nil {
+		return err
+	}
+	if err := decoder.Decode(&e.Time); err != nil {
+		return err
+	}
+	switch {
+	case codes.IsExt(code):
+		e.TimeEncoding = TimeEncodingEventTime
+	case code == codes.Float || code == codes.Double:
+		e.TimeEncoding = TimeEncodingFloat
+	default:
+		e.TimeEncoding = TimeEncodingInteger
+	}
+	return nil
+}
+
+func readPackedStream(v []byte, compressed bool, options DecodeOptions) ([]byte, error) {
+	if !compressed {
+		return v, nil
+	}
+	zreader, err := gzip.NewReader(bytes.NewReader(v))
+	if err != nil {
+		return nil, err
+	}
+	defer zreader.Close()
+	return io.ReadAll(newLimitedReader(zreader, options.MaxDecompressedBytes, "MaxDecompressedBytes"))
 }
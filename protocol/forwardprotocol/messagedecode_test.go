@@ -0,0 +1,74 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestDecodeMessageModes(t *testing.T) {
+	tm := EventTime{time.Date(2022, 1, 14, 10, 30, 55, 123, time.UTC)}
+	record := map[string]interface{}{"msg": "Hello"}
+	option := map[string]interface{}{"chunk": "abc"}
+	entry := []interface{}{&tm, record}
+
+	packed := &bytes.Buffer{}
+	assert.Nil(t, msgpack.NewEncoder(packed).Encode(entry))
+
+	type test struct {
+		fields []interface{}
+		mode   MessageMode
+		option TransportOption
+	}
+
+	testList := []test{
+		{[]interface{}{"foo", &tm, record}, ModeMessage, TransportOption{}},
+		{[]interface{}{"foo", &tm, record, option}, ModeMessage, TransportOption{Chunk: "abc"}},
+		{[]interface{}{"foo", []interface{}{entry}}, ModeForward, TransportOption{}},
+		{[]interface{}{"foo", []interface{}{entry}, option}, ModeForward, TransportOption{Chunk: "abc"}},
+		{[]interface{}{"foo", packed.Bytes()}, ModePackedForward, TransportOption{}},
+		{[]interface{}{"foo", packed.Bytes(), option}, ModePackedForward, TransportOption{Chunk: "abc"}},
+		{[]interface{}{"foo", packed.String(), option}, ModePackedForward, TransportOption{Chunk: "abc"}},
+	}
+
+	for i, test := range testList {
+		binary, err := msgpack.Marshal(test.fields)
+		assert.Nil(t, err, "test[%d]", i)
+
+		var decoded Message
+		assert.Nil(t, msgpack.Unmarshal(binary, &decoded), "test[%d]", i)
+		assert.Equal(t, "foo", decoded.Tag, "test[%d]", i)
+		assert.Equal(t, test.mode, decoded.Mode, "test[%d]", i)
+		assert.Equal(t, test.option, decoded.Option, "test[%d]", i)
+		if assert.Len(t, decoded.Entries, 1, "test[%d]", i) {
+			assert.True(t, tm.Equal(decoded.Entries[0].Time.Time), "test[%d]", i)
+			assert.Equal(t, record, decoded.Entries[0].Record, "test[%d]", i)
+		}
+	}
+}
+
+func TestDecodeMessageInvalidFieldCount(t *testing.T) {
+	tm := EventTime{time.Date(2022, 1, 14, 10, 30, 55, 123, time.UTC)}
+
+	type test struct {
+		fields []interface{}
+		err    string
+	}
+
+	testList := []test{
+		{[]interface{}{"foo"}, "message's field count: 1 (should be 2 to 4)"},
+		{[]interface{}{"foo", &tm}, "message's field count: 2 (should be 3 or 4 in Message mode)"},
+		{[]interface{}{"foo", []interface{}{}, map[string]interface{}{}, 1}, "message's field count: 4 (should be 2 or 3 in Forward mode)"},
+	}
+
+	for i, test := range testList {
+		binary, err := msgpack.Marshal(test.fields)
+		assert.Nil(t, err, "test[%d]", i)
+
+		var decoded Message
+		assert.EqualError(t, msgpack.Unmarshal(binary, &decoded), test.err, "test[%d]", i)
+	}
+}
@@ -0,0 +1,232 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+var _ msgpack.CustomEncoder = EventEntry{}
+
+// EncodeOptions contains options to encode messages and events
+type EncodeOptions struct {
+	Mode          MessageMode // Mode to encode messages
+	EventFormatV2 bool        // Encode events in Fluent Bit v2 format as [[time, metadata], record]
+	TimeAsInteger bool        // Encode time as integer seconds instead of EventTime, same as Fluentd's time_as_integer
+}
+
+// EncodeMessage encodes the message in the given mode, as the reverse of Message.DecodeMsgpack
+//
+// TransportOption.Size and TransportOption.Compressed are filled automatically, other options are kept as-is.
+//
+// ModeMessage requires exactly one entry and it's always encoded with option.
+//
+// Messages with Option.FluentSignal of metrics or traces are encoded from Payload, in packed modes only.
+//
+// Lazy entries are decoded first, see Message.LoadEntries.
+func EncodeMessage(encoder *msgpack.Encoder, message Message, mode MessageMode) error {
+	return EncodeMessageWithOptions(encoder, message, EncodeOptions{
+		Mode:          mode,
+		EventFormatV2: false,
+		TimeAsInteger: false,
+	})
+}
+
+// EncodeMessageWithOptions encodes the message by the given options, see EncodeMessage
+func EncodeMessageWithOptions(encoder *msgpack.Encoder, message Message, options EncodeOptions) error {
+	if err := message.LoadEntries(); err != nil {
+		return fmt.Errorf("message's lazy entries: %w", err)
+	}
+	mode := options.Mode
+	option := message.Option
+	option.Size = len(message.Entries)
+	option.Compressed = ""
+	isSignal := option.FluentSignal != FluentSignalLogs
+	if isSignal {
+		option.Size = 0
+	}
+
+	switch mode {
+	case ModeMessage:
+		if len(message.Entries) != 1 {
+			return fmt.Errorf("%s mode requires exactly one entry: got %d", mode, len(message.Entries))
+		}
+		option.Size = 0
+	case ModeForward, ModePackedForward, ModeCompressedPackedForward:
+		// ok
+	default:
+		return fmt.Errorf("unsupported message mode: '%s'", mode)
+	}
+	switch {
+	case isSignal && mode != ModePackedForward && mode != ModeCompressedPackedForward:
+		return fmt.Errorf("%s mode cannot contain %s", mode, option.FluentSignal)
+	case isSignal && len(message.Entries) > 0:
+		return fmt.Errorf("message of %s cannot contain entries: got %d", option.FluentSignal, len(message.Entries))
+	}
+
+	// first is array length; 4 for Message mode or 3 for others, always with option
+	if mode == ModeMessage {
+		if err := encoder.EncodeArrayLen(4); err != nil {
+			return fmt.Errorf("message's field count: %w", err)
+		}
+	} else if err := encoder.EncodeArrayLen(3); err != nil {
+		return fmt.Errorf("message's field count: %w", err)
+	}
+	// array[0] is tag
+	if err := encoder.EncodeString(message.Tag); err != nil {
+		return fmt.Errorf("message's tag: %w", err)
+	}
+	// array[1] is array of entries, binary or time of the single entry
+	switch mode {
+	case ModeMessage:
+		if err := encodeEventTime(encoder, message.Entries[0].Time, options); err != nil {
+			return fmt.Errorf("message's time: %w", err)
+		}
+		if err := encoder.Encode(message.Entries[0].Record); err != nil {
+			return fmt.Errorf("message's record: %w", err)
+		}
+	case ModeForward:
+		if err := encoder.EncodeArrayLen(len(message.Entries)); err != nil {
+			return fmt.Errorf("message's entries count: %w", err)
+		}
+		for i := range message.Entries {
+			if err := EncodeEventEntry(encoder, message.Entries[i], options); err != nil {
+				return fmt.Errorf("message's entry %d: %w", i, err)
+			}
+		}
+	default:
+		compressed := mode == ModeCompressedPackedForward
+		if compressed {
+			option.Compressed = CompressionFormat
+		}
+		var binary []byte
+		var err error
+		if isSignal {
+			binary, err = encodePackedPayload(message.Payload, compressed)
+		} else {
+			binary, err = encodePackedEntriesStream(message.Entries, compressed, options)
+		}
+		if err != nil {
+			return fmt.Errorf("message's entries binary (compressed=%t): %w", compressed, err)
+		}
+		if err := encoder.EncodeBytes(binary); err != nil {
+			return fmt.Errorf("message's entries as binary: %w", err)
+		}
+	}
+	// array[2] or array[3] is option
+	if err := encoder.Encode(&option); err != nil {
+		return fmt.Errorf("message's option map: %w", err)
+	}
+	return nil
+}
+
+// MarshalMessage encodes the message in the given mode and returns the serialized bytes
+//
+// See EncodeMessage
+func MarshalMessage(message Message, mode MessageMode) ([]byte, error) {
+	return MarshalMessageWithOptions(message, EncodeOptions{
+		Mode:          mode,
+		EventFormatV2: false,
+		TimeAsInteger: false,
+	})
+}
+
+// MarshalMessageWithOptions encodes the message by the given options and returns the serialized bytes
+//
+// See EncodeMessage
+func MarshalMessageWithOptions(message Message, options EncodeOptions) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	if err := EncodeMessageWithOptions(msgpack.NewEncoder(buffer), message, options); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeMsgpack is the custom msgpack encoding implementation for EventEntry, in the classic format [time, record]
+func (e EventEntry) EncodeMsgpack(encoder *msgpack.Encoder) error {
+	return EncodeEventEntry(encoder, e, EncodeOptions{
+		Mode:          "",
+		EventFormatV2: false,
+		TimeAsInteger: false,
+	})
+}
+
+// EncodeEventEntry encodes a single event by the given options. Mode is ignored.
+//
+// In Fluent Bit v2 format, nil metadata is encoded as an empty map.
+func EncodeEventEntry(encoder *msgpack.Encoder, entry EventEntry, options EncodeOptions) error {
+	// first is array length; always 2
+	if err := encoder.EncodeArrayLen(2); err != nil {
+		return fmt.Errorf("event's field count: %w", err)
+	}
+	// array[0] is time or [time, metadata]
+	if options.EventFormatV2 {
+		if err := encoder.EncodeArrayLen(2); err != nil {
+			return fmt.Errorf("event's header field count: %w", err)
+		}
+		if err := encodeEventTime(encoder, entry.Time, options); err != nil {
+			return fmt.Errorf("event's time: %w", err)
+		}
+		metadata := entry.Metadata
+		if metadata == nil {
+			metadata = map[string]interface{}{}
+		}
+		if err := encoder.Encode(metadata); err != nil {
+			return fmt.Errorf("event's metadata: %w", err)
+		}
+	} else if err := encodeEventTime(encoder, entry.Time, options); err != nil {
+		return fmt.Errorf("event's time: %w", err)
+	}
+	// array[1] is record
+	if err := encoder.Encode(entry.Record); err != nil {
+		return fmt.Errorf("event's record: %w", err)
+	}
+	return nil
+}
+
+func encodeEventTime(encoder *msgpack.Encoder, tm EventTime, options EncodeOptions) error {
+	if options.TimeAsInteger {
+		return encoder.EncodeInt(tm.Unix())
+	}
+	return encoder.Encode(&tm)
+}
+
+func encodePackedEntriesStream(entries []EventEntry, compressed bool, options EncodeOptions) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	var writer io.Writer = buffer
+	var zwriter *gzip.Writer
+	if compressed {
+		zwriter = gzip.NewWriter(buffer)
+		writer = zwriter
+	}
+	encoder := msgpack.NewEncoder(writer)
+	for i := range entries {
+		if err := EncodeEventEntry(encoder, entries[i], options); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+	if zwriter != nil {
+		if err := zwriter.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+func encodePackedPayload(payload []byte, compressed bool) ([]byte, error) {
+	if !compressed {
+		return payload, nil
+	}
+	buffer := &bytes.Buffer{}
+	zwriter := gzip.NewWriter(buffer)
+	if _, err := zwriter.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := zwriter.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestEncodeMessage(t *testing.T) {
+	message := Message{
+		Tag: "foo.bar",
+		Entries: []EventEntry{
+			{
+				Time: EventTime{time.Date(2022, 1, 14, 10, 30, 55, 123, time.UTC)},
+				Record: map[string]interface{}{
+					"msg": "Hello",
+					"http": map[string]interface{}{
+						"statusCode": "500",
+					},
+				},
+			},
+			{
+				Time: EventTime{time.Date(2022, 1, 14, 10, 30, 56, 456, time.UTC)},
+				Record: map[string]interface{}{
+					"msg": "World",
+				},
+			},
+		},
+		Option: TransportOption{Chunk: "abc", Compressed: "unused"},
+	}
+
+	expectedCompression := map[MessageMode]string{
+		ModeForward:                 "",
+		ModePackedForward:           "",
+		ModeCompressedPackedForward: CompressionFormat,
+	}
+
+	for mode, compression := range expectedCompression {
+		binary, err := MarshalMessage(message, mode)
+		assert.Nil(t, err, mode)
+
+		var decoded Message
+		assert.Nil(t, msgpack.NewDecoder(bytes.NewReader(binary)).Decode(&decoded), mode)
+		assert.Equal(t, message.Tag, decoded.Tag, mode)
+		assert.Equal(t, mode, decoded.Mode, mode)
+		assert.Equal(t, TransportOption{Size: 2, Chunk: "abc", Compressed: compression}, decoded.Option, mode)
+		if assert.Len(t, decoded.Entries, len(message.Entries), mode) {
+			for i, entry := range decoded.Entries {
+				assert.True(t, message.Entries[i].Time.Equal(entry.Time.Time), "%s entries[%d]", mode, i)
+				assert.Equal(t, message.Entries[i].Record, entry.Record, "%s entries[%d]", mode, i)
+			}
+		}
+	}
+
+	_, err := MarshalMessage(message, "Unknown")
+	assert.EqualError(t, err, "unsupported message mode: 'Unknown'")
+
+	_, err = MarshalMessage(message, ModeMessage)
+	assert.EqualError(t, err, "Message mode requires exactly one entry: got 2")
+
+	single := message
+	single.Entries = message.Entries[:1]
+	binary, err := MarshalMessage(single, ModeMessage)
+	assert.Nil(t, err)
+
+	var decoded Message
+	assert.Nil(t, msgpack.NewDecoder(bytes.NewReader(binary)).Decode(&decoded))
+	assert.Equal(t, ModeMessage, decoded.Mode)
+	assert.Equal(t, TransportOption{Chunk: "abc"}, decoded.Option)
+	if assert.Len(t, decoded.Entries, 1) {
+		assert.True(t, single.Entries[0].Time.Equal(decoded.Entries[0].Time.Time))
+		assert.Equal(t, single.Entries[0].Record, decoded.Entries[0].Record)
+	}
+}
+
+func TestEncodeEventFormatV2(t *testing.T) {
+	message := Message{
+		Tag: "foo.bar",
+		Entries: []EventEntry{
+			{
+				Time:     EventTime{time.Date(2022, 1, 14, 10, 30, 55, 123, time.UTC)},
+				Record:   map[string]interface{}{"msg": "Hello"},
+				Metadata: map[string]interface{}{"otlp": "yes"},
+			},
+			{
+				Time:     EventTime{time.Date(2022, 1, 14, 10, 30, 56, 456, time.UTC)},
+				Record:   map[string]interface{}{"msg": "World"},
+				Metadata: nil,
+			},
+		},
+	}
+
+	for _, mode := range []MessageMode{ModeForward, ModePackedForward, ModeCompressedPackedForward} {
+		binary, err := MarshalMessageWithOptions(message, EncodeOptions{Mode: mode, EventFormatV2: true})
+		assert.Nil(t, err, mode)
+
+		var decoded Message
+		assert.Nil(t, msgpack.Unmarshal(binary, &decoded), mode)
+		if assert.Len(t, decoded.Entries, 2, mode) {
+			assert.True(t, message.Entries[0].Time.Equal(decoded.Entries[0].Time.Time), mode)
+			assert.Equal(t, message.Entries[0].Record, decoded.Entries[0].Record, mode)
+			assert.Equal(t, message.Entries[0].Metadata, decoded.Entries[0].Metadata, mode)
+			assert.Equal(t, map[string]interface{}{}, decoded.Entries[1].Metadata, mode)
+		}
+	}
+
+	// classic format drops metadata
+	binary, err := MarshalMessage(message, ModeForward)
+	assert.Nil(t, err)
+
+	var decoded Message
+	assert.Nil(t, msgpack.Unmarshal(binary, &decoded))
+	if assert.Len(t, decoded.Entries, 2) {
+		assert.Nil(t, decoded.Entries[0].Metadata)
+		assert.Equal(t, message.Entries[0].Record, decoded.Entries[0].Record)
+	}
+}
+
+func TestEncodeFluentSignal(t *testing.T) {
+	payload, err := msgpack.Marshal(map[string]interface{}{"metrics": []interface{}{}})
+	assert.Nil(t, err)
+	message := Message{
+		Tag:     "metrics",
+		Option:  TransportOption{Chunk: "abc", FluentSignal: FluentSignalMetrics},
+		Payload: payload,
+	}
+
+	for _, mode := range []MessageMode{ModePackedForward, ModeCompressedPackedForward} {
+		binary, err := MarshalMessage(message, mode)
+		assert.Nil(t, err, mode)
+
+		var decoded Message
+		assert.Nil(t, msgpack.Unmarshal(binary, &decoded), mode)
+		assert.Equal(t, mode, decoded.Mode, mode)
+		assert.Equal(t, FluentSignalMetrics, decoded.Option.FluentSignal, mode)
+		assert.Equal(t, payload, decoded.Payload, mode)
+		assert.Empty(t, decoded.Entries, mode)
+	}
+
+	_, err = MarshalMessage(message, ModeForward)
+	assert.EqualError(t, err, "Forward mode cannot contain metrics")
+
+	message.Entries = []EventEntry{{}}
+	_, err = MarshalMessage(message, ModePackedForward)
+	assert.EqualError(t, err, "message of metrics cannot contain entries: got 1")
+}
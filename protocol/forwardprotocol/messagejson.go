@@ -0,0 +1,248 @@
+package forwardprotocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// IsJSONStart returns true if the first byte of a stream indicates JSON instead of msgpack, same as Fluentd's in_forward
+//
+// Msgpack messages always start with an array header, which never collides with '[' or '{'
+func IsJSONStart(firstByte byte) bool {
+	return firstByte == '[' || firstByte == '{'
+}
+
+// JSONMessageDecoder decodes messages in JSON format one after another from a stream
+//
+// Only Message mode ["tag", time, {record}, {option}] and Forward mode ["tag", [[time, {record}], ...], {option}] are
+// supported. Time may be integer or float seconds. Integers in records are decoded as int64 and others as float64.
+//
+// DecodeOptions.MaxMessageBytes stops reading once a message exceeds it. Other limits are checked during decoding.
+// LazyEntries is not supported.
+type JSONMessageDecoder struct {
+	reader  *limitedReader
+	decoder *json.Decoder
+	options DecodeOptions
+}
+
+// NewJSONMessageDecoder creates a JSONMessageDecoder reading from the given reader with its own buffering
+func NewJSONMessageDecoder(reader io.Reader, options DecodeOptions) *JSONMessageDecoder {
+	lreader := newLimitedReader(reader, options.MaxMessageBytes, "MaxMessageBytes")
+	return &JSONMessageDecoder{
+		reader:  lreader,
+		decoder: json.NewDecoder(lreader),
+		options: options,
+	}
+}
+
+// Decode decodes the next message, or returns error including *LimitError
+//
+// The stream cannot be recovered after any error
+func (d *JSONMessageDecoder) Decode() (Message, error) {
+	var msg Message
+	// bytes read ahead by json.Decoder are the start of this message, already counted for the previous one
+	readAhead := 0
+	if buffered, ok := d.decoder.Buffered().(interface{ Len() int }); ok {
+		readAhead = buffered.Len()
+	}
+	d.reader.resetTo(readAhead)
+	var fields []json.RawMessage
+	if err := d.decoder.Decode(&fields); err != nil {
+		return msg, err
+	}
+	err := msg.decodeJSON(fields, d.options)
+	return msg, err
+}
+
+// UnmarshalMessageJSON decodes a single message in JSON format, see JSONMessageDecoder
+func UnmarshalMessageJSON(data []byte, options DecodeOptions) (Message, error) {
+	return NewJSONMessageDecoder(bytes.NewReader(data), options).Decode()
+}
+
+func (msg *Message) decodeJSON(fields []json.RawMessage, options DecodeOptions) error {
+	if len(fields) < 2 || len(fields) > 4 {
+		return fmt.Errorf("message's field count: %d (should be 2 to 4)", len(fields))
+	}
+	// array[0] is tag
+	if err := json.Unmarshal(fields[0], &msg.Tag); err != nil {
+		return fmt.Errorf("message's tag: %w", err)
+	}
+	if err := options.checkString(len(msg.Tag)); err != nil {
+		return fmt.Errorf("message's tag: %w", err)
+	}
+	// array[1] is array of entries or time of the single entry
+	optionIndex := 2
+	switch firstJSONByte(fields[1]) {
+	case '[':
+		msg.Mode = ModeForward
+		var entries []json.RawMessage
+		if err := json.Unmarshal(fields[1], &entries); err != nil {
+			return fmt.Errorf("message's entries as array of logs: %w", err)
+		}
+		if err := options.checkEntries(len(entries)); err != nil {
+			return fmt.Errorf("message's entries as array of logs: %w", err)
+		}
+		msg.Entries = make([]EventEntry, len(entries))
+		for i, raw := range entries {
+			if err := decodeJSONEventEntry(raw, &msg.Entries[i], options); err != nil {
+				return fmt.Errorf("message's entry %d: %w", i, err)
+			}
+		}
+	case '"':
+		return fmt.Errorf("message's entries: packed forward modes are not supported in JSON")
+	default:
+		msg.Mode = ModeMessage
+		if len(fields) < 3 {
+			return fmt.Errorf("message's field count: %d (should be 3 or 4 in %s mode)", len(fields), msg.Mode)
+		}
+		entry := EventEntry{}
+		if err := decodeJSONTime(fields[1], &entry); err != nil {
+			return fmt.Errorf("message's time: %w", err)
+		}
+		record, err := decodeJSONRecord(fields[2], options)
+		if err != nil {
+			return fmt.Errorf("message's record: %w", err)
+		}
+		entry.Record = record
+		msg.Entries = []EventEntry{entry}
+		optionIndex = 3
+	}
+	if len(fields) > optionIndex+1 {
+		return fmt.Errorf("message's field count: %d (should be %d or %d in %s mode)", len(fields), optionIndex, optionIndex+1, msg.Mode)
+	}
+	// array[2] or array[3] is option if present
+	msg.Option = TransportOption{}
+	if len(fields) > optionIndex {
+		if err := json.Unmarshal(fields[optionIndex], &msg.Option); err != nil {
+			return fmt.Errorf("message's option map: %w", err)
+		}
+	}
+	return nil
+}
+
+// decodeJSONEventEntry decodes [time, record] or [[time, metadata], record]
+func decodeJSONEventEntry(raw json.RawMessage, e *EventEntry, options DecodeOptions) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("event: %w", err)
+	}
+	if len(fields) != 2 {
+		return fmt.Errorf("event's field count: %d (should be 2)", len(fields))
+	}
+	if firstJSONByte(fields[0]) == '[' {
+		var header []json.RawMessage
+		if err := json.Unmarshal(fields[0], &header); err != nil {
+			return fmt.Errorf("event's header: %w", err)
+		}
+		if len(header) != 2 {
+			return fmt.Errorf("event's header field count: %d (should be 2)", len(header))
+		}
+		if err := decodeJSONTime(header[0], e); err != nil {
+			return fmt.Errorf("event's time: %w", err)
+		}
+		metadata, err := decodeJSONRecord(header[1], options)
+		if err != nil {
+			return fmt.Errorf("event's metadata: %w", err)
+		}
+		e.Metadata = metadata
+	} else if err := decodeJSONTime(fields[0], e); err != nil {
+		return fmt.Errorf("event's time: %w", err)
+	}
+	record, err := decodeJSONRecord(fields[1], options)
+	if err != nil {
+		return fmt.Errorf("event's record: %w", err)
+	}
+	e.Record = record
+	return nil
+}
+
+// decodeJSONTime decodes the time of an event from integer or float seconds, and saves the representation in TimeEncoding
+func decodeJSONTime(raw json.RawMessage, e *EventEntry) error {
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err != nil {
+		return err
+	}
+	if sec, err := number.Int64(); err == nil {
+		e.Time = EventTime{Time: time.Unix(sec, 0)}
+		e.TimeEncoding = TimeEncodingInteger
+		return nil
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return err
+	}
+	e.Time = EventTime{Time: floatSecondsToTime(seconds)}
+	e.TimeEncoding = TimeEncodingFloat
+	return nil
+}
+
+func decodeJSONRecord(raw json.RawMessage, options DecodeOptions) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var record map[string]interface{}
+	if err := decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+	value, err := convertJSONValue(record, 1, options)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return value.(map[string]interface{}), nil
+}
+
+// convertJSONValue converts json.Number to int64 or float64 and checks limits, recursively
+func convertJSONValue(value interface{}, depth int, options DecodeOptions) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return nil, nil
+		}
+		if err := checkDepth(depth, options); err != nil {
+			return nil, err
+		}
+		for key, val := range v {
+			if err := options.checkString(len(key)); err != nil {
+				return nil, err
+			}
+			converted, err := convertJSONValue(val, depth+1, options)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+		return v, nil
+	case []interface{}:
+		if err := checkDepth(depth, options); err != nil {
+			return nil, err
+		}
+		for i, val := range v {
+			converted, err := convertJSONValue(val, depth+1, options)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case string:
+		return v, options.checkString(len(v))
+	default:
+		return v, nil
+	}
+}
+
+func firstJSONByte(raw json.RawMessage) byte {
+	trimmed := strings.TrimLeft(string(raw), " \t\r\n")
+	if len(trimmed) == 0 {
+		return 0
+	}
+	return trimmed[0]
+}
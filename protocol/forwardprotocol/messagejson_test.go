@@ -0,0 +1,85 @@
+package forwardprotocol
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeJSONMessage(t *testing.T) {
+	stream := `["foo.bar", 1642156255, {"msg": "Hello", "n": 1, "f": 1.5}, {"chunk": "abc"}]
+["foo.bar", [[1642156255.5, {"msg": "A"}], [[1642156256, {"k": "v"}], {"msg": "B", "list": [1, {"x": null}]}]]]`
+	decoder := NewJSONMessageDecoder(strings.NewReader(stream), DecodeOptions{})
+
+	msg, err := decoder.Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, "foo.bar", msg.Tag)
+	assert.Equal(t, ModeMessage, msg.Mode)
+	assert.Equal(t, TransportOption{Chunk: "abc"}, msg.Option)
+	if assert.Len(t, msg.Entries, 1) {
+		assert.Equal(t, time.Unix(1642156255, 0), msg.Entries[0].Time.Time)
+		assert.Equal(t, TimeEncodingInteger, msg.Entries[0].TimeEncoding)
+		assert.Equal(t, map[string]interface{}{"msg": "Hello", "n": int64(1), "f": 1.5}, msg.Entries[0].Record)
+	}
+
+	msg, err = decoder.Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, ModeForward, msg.Mode)
+	assert.Equal(t, TransportOption{}, msg.Option)
+	if assert.Len(t, msg.Entries, 2) {
+		assert.Equal(t, time.Unix(1642156255, 500000000), msg.Entries[0].Time.Time)
+		assert.Equal(t, TimeEncodingFloat, msg.Entries[0].TimeEncoding)
+		assert.Nil(t, msg.Entries[0].Metadata)
+		assert.Equal(t, map[string]interface{}{"k": "v"}, msg.Entries[1].Metadata)
+		assert.Equal(t, map[string]interface{}{"msg": "B", "list": []interface{}{int64(1), map[string]interface{}{"x": nil}}}, msg.Entries[1].Record)
+	}
+
+	_, err = UnmarshalMessageJSON([]byte(`["foo"]`), DecodeOptions{})
+	assert.EqualError(t, err, "message's field count: 1 (should be 2 to 4)")
+	_, err = UnmarshalMessageJSON([]byte(`["foo", "packed", {}]`), DecodeOptions{})
+	assert.EqualError(t, err, "message's entries: packed forward modes are not supported in JSON")
+	_, err = UnmarshalMessageJSON([]byte(`["foo", [], {}, {}]`), DecodeOptions{})
+	assert.EqualError(t, err, "message's field count: 4 (should be 2 or 3 in Forward mode)")
+
+	var limitErr *LimitError
+	_, err = UnmarshalMessageJSON([]byte(`["foo", [[1, {"a": 1}], [2, {"b": 2}]]]`), DecodeOptions{MaxEntries: 1})
+	assert.True(t, errors.As(err, &limitErr))
+	_, err = UnmarshalMessageJSON([]byte(`["foo", 1, {"a": {"b": 1}}]`), DecodeOptions{MaxNestingDepth: 1})
+	assert.True(t, errors.As(err, &limitErr))
+	_, err = UnmarshalMessageJSON([]byte(`["foo", 1, {"a": "long"}]`), DecodeOptions{MaxStringLength: 3})
+	assert.True(t, errors.As(err, &limitErr))
+	_, err = UnmarshalMessageJSON([]byte(`["foo", 1, {"a": "long"}]`), DecodeOptions{MaxMessageBytes: 10})
+	assert.True(t, errors.As(err, &limitErr))
+}
+
+func TestDecodeJSONMessageBytesLimit(t *testing.T) {
+	// the limit applies to each message separately, regardless of reading ahead
+	message := `["foo", 1, {"a": "long"}]`
+	decoder := NewJSONMessageDecoder(strings.NewReader(strings.Repeat(message, 3)), DecodeOptions{MaxMessageBytes: len(message)})
+	for i := 0; i < 3; i++ {
+		_, err := decoder.Decode()
+		assert.Nil(t, err, i)
+	}
+
+	// reading stops at the limit instead of buffering the whole message
+	endless := io.MultiReader(strings.NewReader(`["foo", 1, {"a": "`), endlessReader('x'))
+	_, err := NewJSONMessageDecoder(endless, DecodeOptions{MaxMessageBytes: 1000}).Decode()
+	var limitErr *LimitError
+	if assert.True(t, errors.As(err, &limitErr), err) {
+		assert.Equal(t, "MaxMessageBytes", limitErr.Limit)
+	}
+}
+
+// endlessReader reads the same byte forever
+type endlessReader byte
+
+func (r endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
+	"github.com/relex/gotils/logger"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// ackEncoder is the common interface of msgpack.Encoder and json.Encoder
+type ackEncoder interface {
+	Encode(v interface{}) error
+}
+
+// pendingAck is an ack to be sent by runAcknowledger, with the faults decided by the connection
+type pendingAck struct {
+	chunkID   string        // Chunk ID of the received message
+	ackID     string        // Chunk ID to ack, different from chunkID for wrong acks
+	phantomID string        // Chunk ID never received to ack before this one, or empty
+	malformed interface{}   // Response to send instead of a valid ack, or nil
+	duplicate bool          // Ack twice
+	reorder   bool          // Hold until the next ack is sent
+	delay     time.Duration // Delay before sending, which also holds the acks after
+}
+
+// malformedAcks are invalid responses to a chunk, as functions of the chunk ID
+var malformedAcks = []func(chunkID string) interface{}{
+	func(chunkID string) interface{} { return map[string]interface{}{"ack": len(chunkID)} },
+	func(chunkID string) interface{} { return map[string]interface{}{"chunk": chunkID} },
+	func(chunkID string) interface{} { return []string{chunkID} },
+	func(chunkID string) interface{} { return chunkID },
+}
+
+// makePendingAck decides the faults of the ack to the given chunk ID, by scenario or random chances of the listener
+//
+// Random values are drawn here in the connection's goroutine, to keep them reproducible by the seed.
+func (lsnr *forwardListener) makePendingAck(faults *connFaults, chunkID string, clogger logger.Logger) pendingAck {
+	ack := pendingAck{
+		chunkID:   chunkID,
+		ackID:     chunkID,
+		phantomID: "",
+		malformed: nil,
+		duplicate: false,
+		reorder:   false,
+		delay:     0,
+	}
+	if apply, reason := faults.check(scenarioWrongAck, lsnr.config.RandomWrongAck); apply {
+		ack.ackID = randomChunkID(faults)
+		clogger.Infof("ack %s with wrong chunk ID %s %s", chunkID, ack.ackID, reason)
+	}
+	if apply, reason := faults.check(scenarioDuplicateAck, lsnr.config.RandomDuplicateAck); apply {
+		ack.duplicate = true
+		clogger.Infof("ack %s twice %s", chunkID, reason)
+	}
+	if apply, reason := faults.check(scenarioReorderAck, lsnr.config.RandomReorderAck); apply {
+		ack.reorder = true
+		clogger.Infof("hold ack of %s until the next %s", chunkID, reason)
+	}
+	if apply, reason := faults.check(scenarioPhantomAck, lsnr.config.RandomPhantomAck); apply {
+		ack.phantomID = randomChunkID(faults)
+		clogger.Infof("ack chunk never received %s before %s %s", ack.phantomID, chunkID, reason)
+	}
+	if apply, reason := faults.check(scenarioMalformedAck, lsnr.config.RandomMalformedAck); apply {
+		ack.malformed = malformedAcks[faults.rand.Intn(len(malformedAcks))](ack.ackID)
+		clogger.Infof("respond %s with malformed ack %v %s", chunkID, ack.malformed, reason)
+	}
+	if apply, reason := faults.check(scenarioDelayAck, lsnr.config.RandomDelayAck); apply {
+		ack.delay = lsnr.ackDelay(faults)
+		clogger.Infof("delay ack of %s by %s %s", chunkID, ack.delay, reason)
+	}
+	return ack
+}
+
+// randomChunkID generates a chunk ID in the same format as forwardprotocol.NewChunkID from the random source of
+// connection
+func randomChunkID(faults *connFaults) string {
+	var id [16]byte
+	faults.rand.Read(id[:])
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+// runAcknowledger sends acks in order until ackChannel is closed, and then the held one if any
+func (server *ForwardServer) runAcknowledger(ackChannel chan pendingAck, ackEnded chan struct{}, conn net.Conn, isJSON bool, clogger logger.Logger) {
+	defer close(ackEnded)
+	alogger := clogger.WithField("part", "acknowledger")
+	cwriter := bufio.NewWriter(conn)
+	var encoder ackEncoder = msgpack.NewEncoder(cwriter)
+	if isJSON {
+		encoder = json.NewEncoder(cwriter)
+	}
+	send := func(response interface{}) bool {
+		if err := conn.SetWriteDeadline(time.Now().Add(server.config.AckTimeout)); err != nil {
+			alogger.Error("unable to set write timeout: ", err)
+			return false
+		}
+		if err := encoder.Encode(response); err != nil {
+			alogger.Error("unable to ack: ", err)
+			return false
+		}
+		if err := cwriter.Flush(); err != nil {
+			alogger.Error("unable to ack: ", err)
+			return false
+		}
+		return true
+	}
+	var held *pendingAck
+	for ack := range ackChannel {
+		if ack.delay > 0 {
+			select {
+			case <-time.After(ack.delay):
+			case <-server.aborting.Done():
+				return
+			}
+		}
+		if ack.phantomID != "" && !send(&forwardprotocol.Ack{Ack: ack.phantomID}) {
+			return
+		}
+		if ack.reorder && held == nil {
+			heldAck := ack
+			held = &heldAck
+			continue
+		}
+		if !sendAck(send, ack) {
+			return
+		}
+		if held != nil {
+			if !sendAck(send, *held) {
+				return
+			}
+			held = nil
+		}
+	}
+	if held != nil && !sendAck(send, *held) {
+		return
+	}
+	alogger.Infof("end")
+}
+
+// sendAck sends the ack or its malformed replacement, twice if duplicated
+func sendAck(send func(response interface{}) bool, ack pendingAck) bool {
+	var response interface{} = &forwardprotocol.Ack{Ack: ack.ackID}
+	if ack.malformed != nil {
+		response = ack.malformed
+	}
+	if !send(response) {
+		return false
+	}
+	if ack.duplicate {
+		return send(response)
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/relex/fluentlib/server/receivers"
+	"github.com/relex/gotils/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerAckFaults(t *testing.T) {
+	recv := &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address:           "localhost:0",
+			RandomDelayAckMin: 200 * time.Millisecond,
+			RandomDelayAckMax: 300 * time.Millisecond,
+		},
+		Scenario: []ScenarioRule{
+			{Action: "wrong_ack", Messages: "1"},
+			{Action: "duplicate_ack", Messages: "2"},
+			{Action: "reorder_ack", Messages: "3"},
+			{Action: "phantom_ack", Messages: "5"},
+			{Action: "malformed_ack", Messages: "6"},
+			{Action: "delay_ack", Messages: "7"},
+		},
+	}, recv)
+	defer srv.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	startTime := time.Now()
+	for i := 1; i <= 7; i++ {
+		_, err := conn.Write([]byte(fmt.Sprintf(`["hello", 1604106123, {"field1": "foo"}, {"chunk": "c%d"}]`, i)))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	jdecoder := json.NewDecoder(conn)
+	nextResponse := func() interface{} {
+		var response interface{}
+		assert.Nil(t, jdecoder.Decode(&response))
+		return response
+	}
+	nextAck := func() string {
+		response, _ := nextResponse().(map[string]interface{})
+		ack, _ := response["ack"].(string)
+		return ack
+	}
+
+	wrongAck := nextAck()
+	assert.NotEmpty(t, wrongAck)
+	assert.NotEqual(t, "c1", wrongAck)
+	assert.Equal(t, "c2", nextAck())
+	assert.Equal(t, "c2", nextAck(), "duplicate")
+	assert.Equal(t, "c4", nextAck(), "reordered")
+	assert.Equal(t, "c3", nextAck(), "reordered")
+	phantomAck := nextAck()
+	assert.NotEmpty(t, phantomAck)
+	assert.NotEqual(t, "c5", phantomAck)
+	assert.Equal(t, "c5", nextAck())
+	malformed := nextResponse()
+	assert.NotEqual(t, map[string]interface{}{"ack": "c6"}, malformed)
+	assert.Equal(t, "c7", nextAck())
+	assert.GreaterOrEqual(t, time.Since(startTime), 200*time.Millisecond, "delayed")
+
+	for i := 1; i <= 7; i++ {
+		assert.Equal(t, fmt.Sprintf("c%d", i), (<-recv.ch).Option.Chunk)
+	}
+}
+
+func TestServerAckFaultsHeldOnClose(t *testing.T) {
+	recv := &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+		Scenario: []ScenarioRule{
+			{Action: "reorder_ack", Messages: "1"},
+			{Action: "kill_conn", Messages: "2"},
+		},
+	}, recv)
+	defer srv.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(`["hello", 1604106123, {"field1": "foo"}, {"chunk": "c1"}]`))
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte(`["hello", 1604106123, {"field1": "foo"}, {"chunk": "c2"}]`))
+	assert.Nil(t, err)
+	assert.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var response map[string]interface{}
+	assert.Nil(t, json.NewDecoder(conn).Decode(&response))
+	assert.Equal(t, "c1", response["ack"], "held ack is sent before closing")
+	assert.Equal(t, "c1", (<-recv.ch).Option.Chunk)
+}
+
+func TestAckDelay(t *testing.T) {
+	for _, dist := range []string{"", "uniform", "exponential"} {
+		lsnr := &forwardListener{config: ListenerConfig{
+			RandomDelayAckMin:  time.Second,
+			RandomDelayAckMax:  3 * time.Second,
+			RandomDelayAckDist: dist,
+		}}
+		faults := &connFaults{rand: newRand(1, 1)}
+		total := time.Duration(0)
+		for i := 0; i < 1000; i++ {
+			delay := lsnr.ackDelay(faults)
+			assert.GreaterOrEqual(t, delay, time.Second, dist)
+			assert.LessOrEqual(t, delay, 3*time.Second, dist)
+			total += delay
+		}
+		assert.InDelta(t, 2*time.Second, total/1000, float64(200*time.Millisecond), dist)
+	}
+
+	// defaults for library users without the CLI
+	defaultRanges := map[time.Duration][2]time.Duration{
+		0:                {time.Second, 10 * time.Second},
+		20 * time.Second: {20 * time.Second, 20 * time.Second},
+	}
+	for min, expected := range defaultRanges {
+		lsnr, err := openListener(logger.Root(), ListenerConfig{Address: "localhost:0", RandomDelayAckMin: min}, nil)
+		if assert.Nil(t, err) {
+			assert.Equal(t, expected, [2]time.Duration{lsnr.config.RandomDelayAckMin, lsnr.config.RandomDelayAckMax})
+			lsnr.close()
+		}
+	}
+
+	errorListeners := map[string]ListenerConfig{
+		"random delay ack: invalid range 2s to 1s":        {RandomDelayAckMin: 2 * time.Second, RandomDelayAckMax: time.Second},
+		"random delay ack: unknown distribution 'normal'": {RandomDelayAckDist: "normal"},
+	}
+	for expectedErr, config := range errorListeners {
+		_, err := openListener(logger.Root(), config, nil)
+		assert.ErrorContains(t, err, expectedErr)
+	}
+}
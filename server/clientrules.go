@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
+)
+
+// loadClientRules parses Config.ClientRules, each as "key=value;key=value..."
+//
+// Keys are "network" (CIDR), "host" (glob pattern of hostname in PING), "shared_key" and "users" (separated by '|'),
+// same as the parameters of <client> in Fluentd's <security> section. Commas cannot be used as they separate rules.
+func loadClientRules(config ListenerConfig) ([]forwardprotocol.ClientRule, error) {
+	rules := make([]forwardprotocol.ClientRule, 0, len(config.ClientRules))
+	for i, entry := range config.ClientRules {
+		rule, err := parseClientRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d '%s': %w", i, entry, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseClientRule(entry string) (forwardprotocol.ClientRule, error) {
+	rule := forwardprotocol.ClientRule{
+		Network:   nil,
+		Hostname:  "",
+		SharedKey: "",
+		Usernames: nil,
+	}
+	for _, field := range strings.Split(entry, ";") {
+		if len(field) == 0 {
+			continue
+		}
+		key, value, found := strings.Cut(field, "=")
+		if !found || len(value) == 0 {
+			return rule, fmt.Errorf("invalid field, should be 'key=value': %s", field)
+		}
+		switch key {
+		case "network":
+			_, network, err := net.ParseCIDR(value)
+			if err != nil {
+				return rule, fmt.Errorf("network: %w", err)
+			}
+			rule.Network = network
+		case "host":
+			if _, err := path.Match(value, ""); err != nil {
+				return rule, fmt.Errorf("host: %w", err)
+			}
+			rule.Hostname = value
+		case "shared_key":
+			rule.SharedKey = value
+		case "users":
+			rule.Usernames = strings.Split(value, "|")
+		default:
+			return rule, fmt.Errorf("unknown key '%s', should be network, host, shared_key or users", key)
+		}
+	}
+	return rule, nil
+}
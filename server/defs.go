@@ -2,14 +2,31 @@ package server
 
 import "time"
 
+// defs contains the defaults of timeouts in Config and stalls and delays in ListenerConfig
 var defs = struct {
 	ForwarderHandshakeTimeout     time.Duration
 	ForwarderBatchSendTimeoutBase time.Duration
 	ForwarderBatchAckTimeout      time.Duration
 	WriterEndingTimeout           time.Duration
+	NoHandshakeStall              time.Duration
+	NoReceivingStall              time.Duration
+	DelayAckMin                   time.Duration
+	DelayAckMax                   time.Duration
 }{
 	ForwarderHandshakeTimeout:     10 * time.Second,
 	ForwarderBatchSendTimeoutBase: 30 * time.Second,
 	ForwarderBatchAckTimeout:      30 * time.Second,
 	WriterEndingTimeout:           5 * time.Second,
+	NoHandshakeStall:              60 * time.Second,
+	NoReceivingStall:              30 * time.Second,
+	DelayAckMin:                   1 * time.Second,
+	DelayAckMax:                   10 * time.Second,
+}
+
+// durationOrDefault returns the given duration, or the default if it's zero
+func durationOrDefault(value time.Duration, defaultValue time.Duration) time.Duration {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
 }
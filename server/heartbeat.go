@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
+)
+
+// maxDelayedHeartbeats limits heartbeats waiting for HeartbeatDelay, beyond which new heartbeats are dropped
+const maxDelayedHeartbeats = 1000
+
+// delayedHeartbeat is a heartbeat to be responded when due
+type delayedHeartbeat struct {
+	addr net.Addr
+	due  time.Time
+}
+
+// runHeartbeat responds to UDP heartbeats of the listener until it is closed
+//
+// Like Fluentd's in_forward, any packet is treated as a heartbeat and answered by HeartbeatPayload to the sender.
+// Heartbeats are dropped while the server is down by scenario.
+func (server *ForwardServer) runHeartbeat(lsnr *forwardListener, faults *connFaults) {
+	heartbeatConn := lsnr.udpConn
+	hlogger := lsnr.logger.WithField("part", "heartbeat")
+	respond := func(addr net.Addr) {
+		if _, err := heartbeatConn.WriteTo(forwardprotocol.HeartbeatPayload, addr); err != nil {
+			hlogger.Warnf("unable to respond heartbeat to %s: %v", addr, err)
+			return
+		}
+		hlogger.Debugf("responded heartbeat to %s", addr)
+	}
+	var delayedChannel chan delayedHeartbeat
+	if lsnr.config.HeartbeatDelay > 0 {
+		delayedChannel = make(chan delayedHeartbeat, maxDelayedHeartbeats)
+		defer close(delayedChannel)
+		go server.runDelayedHeartbeats(delayedChannel, respond)
+	}
+	buffer := make([]byte, 1024)
+	for {
+		_, addr, err := heartbeatConn.ReadFrom(buffer)
+		if err != nil {
+			hlogger.Info("heartbeat listener stopped: ", err)
+			return
+		}
+		if drop, reason := faults.check(scenarioDown, lsnr.config.RandomDropHeartbeat); drop {
+			hlogger.Infof("drop heartbeat from %s %s", addr, reason)
+			continue
+		}
+		if delayedChannel == nil {
+			respond(addr)
+			continue
+		}
+		select {
+		case delayedChannel <- delayedHeartbeat{addr: addr, due: time.Now().Add(lsnr.config.HeartbeatDelay)}:
+		default:
+			hlogger.Warnf("drop heartbeat from %s for too many delayed ones", addr)
+		}
+	}
+}
+
+// runDelayedHeartbeats responds to delayed heartbeats in order, until the channel is closed or the server is draining
+//
+// All heartbeats of a listener have the same delay, so that they're due in the same order as received.
+func (server *ForwardServer) runDelayedHeartbeats(delayedChannel <-chan delayedHeartbeat, respond func(addr net.Addr)) {
+	for heartbeat := range delayedChannel {
+		select {
+		case <-time.After(time.Until(heartbeat.due)):
+		case <-server.draining.Done():
+			return
+		}
+		respond(heartbeat.addr)
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/strcase"
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
+	"github.com/relex/gotils/logger"
+)
+
+// Distributions for ListenerConfig.RandomDelayAckDist
+const (
+	ackDelayUniform     = "uniform"     // Uniform between min and max
+	ackDelayExponential = "exponential" // Exponential from min with mean at the middle, capped at max
+)
+
+// forwardListener is a listener of ForwardServer with its own address, TLS, authentication and fault settings
+type forwardListener struct {
+	logger    logger.Logger
+	config    ListenerConfig
+	users     map[string]string
+	tenants   map[string]string
+	rules     []forwardprotocol.ClientRule
+	tlsConfig *tls.Config
+	listener  net.Listener
+	udpConn   net.PacketConn
+}
+
+// openListener loads the settings and starts listening, including UDP heartbeats if enabled
+//
+// The test CA shared by listeners is used if TLSTestCA is set, or a new one if nil.
+func openListener(parentLogger logger.Logger, config ListenerConfig, ca *testCA) (*forwardListener, error) {
+	llogger := parentLogger.WithField("listener", config.Name)
+	if config.RandomStallJitter < 0 || config.RandomStallJitter > 1 {
+		return nil, fmt.Errorf("random stall jitter: %f is out of range 0.0 to 1.0", config.RandomStallJitter)
+	}
+	config.RandomNoHandshakeStall = durationOrDefault(config.RandomNoHandshakeStall, defs.NoHandshakeStall)
+	config.RandomNoReceivingStall = durationOrDefault(config.RandomNoReceivingStall, defs.NoReceivingStall)
+	config.RandomDelayAckMin = durationOrDefault(config.RandomDelayAckMin, defs.DelayAckMin)
+	if config.RandomDelayAckMax == 0 && config.RandomDelayAckMin > defs.DelayAckMax {
+		config.RandomDelayAckMax = config.RandomDelayAckMin
+	}
+	config.RandomDelayAckMax = durationOrDefault(config.RandomDelayAckMax, defs.DelayAckMax)
+	if config.RandomDelayAckMin < 0 || config.RandomDelayAckMax < config.RandomDelayAckMin {
+		return nil, fmt.Errorf("random delay ack: invalid range %s to %s", config.RandomDelayAckMin, config.RandomDelayAckMax)
+	}
+	switch config.RandomDelayAckDist {
+	case "", ackDelayUniform, ackDelayExponential:
+	default:
+		return nil, fmt.Errorf("random delay ack: unknown distribution '%s'", config.RandomDelayAckDist)
+	}
+	users, usersErr := loadUsers(config)
+	if usersErr != nil {
+		return nil, fmt.Errorf("users: %w", usersErr)
+	}
+	tenants, tenantsErr := loadTenants(config)
+	if tenantsErr != nil {
+		return nil, fmt.Errorf("tenants: %w", tenantsErr)
+	}
+	rules, rulesErr := loadClientRules(config)
+	if rulesErr != nil {
+		return nil, fmt.Errorf("client rules: %w", rulesErr)
+	}
+	tlsConfig, tlsErr := loadTLSConfig(config, ca)
+	if tlsErr != nil {
+		return nil, fmt.Errorf("TLS: %w", tlsErr)
+	}
+	var lsnr net.Listener
+	var err error
+	network, address := forwardprotocol.ParseAddress(config.Address)
+	if network == "unix" {
+		lsnr, err = listenUnix(address, config.UnixSocketMode)
+	} else {
+		lsnr, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	llogger.Infof("listening to %s", lsnr.Addr())
+	var udpConn net.PacketConn
+	if config.Heartbeat && network == "unix" {
+		llogger.Info("heartbeat is not available on Unix socket")
+	} else if config.Heartbeat {
+		udpConn, err = net.ListenPacket("udp", lsnr.Addr().String())
+		if err != nil {
+			lsnr.Close()
+			return nil, fmt.Errorf("listen heartbeat: %w", err)
+		}
+		llogger.Infof("listening to heartbeats at %s", udpConn.LocalAddr())
+	}
+	return &forwardListener{
+		logger:    llogger,
+		config:    config,
+		users:     users,
+		tenants:   tenants,
+		rules:     rules,
+		tlsConfig: tlsConfig,
+		listener:  lsnr,
+		udpConn:   udpConn,
+	}, nil
+}
+
+func (lsnr *forwardListener) close() {
+	lsnr.listener.Close()
+	if lsnr.udpConn != nil {
+		lsnr.udpConn.Close()
+	}
+}
+
+// makeAuthCallback creates the callback to reject clients by scenario or RandomFailAuth
+func (lsnr *forwardListener) makeAuthCallback(faults *connFaults, clogger logger.Logger) forwardprotocol.AuthCallback {
+	return func(hostname, username, password string) (bool, string) {
+		if fail, reason := faults.check(scenarioFailAuth, lsnr.config.RandomFailAuth); fail {
+			clogger.Info("reject client auth ", reason)
+			return false, "bad luck"
+		}
+		return true, ""
+	}
+}
+
+// stallDuration returns the given stall duration varied by RandomStallJitter
+func (lsnr *forwardListener) stallDuration(faults *connFaults, stall time.Duration) time.Duration {
+	jitter := lsnr.config.RandomStallJitter * (2*faults.rand.Float64() - 1)
+	return time.Duration(float64(stall) * (1 + jitter))
+}
+
+// ackDelay returns a random delay for delayed acks, between RandomDelayAckMin and RandomDelayAckMax
+func (lsnr *forwardListener) ackDelay(faults *connFaults) time.Duration {
+	min := lsnr.config.RandomDelayAckMin
+	span := float64(lsnr.config.RandomDelayAckMax - min)
+	if lsnr.config.RandomDelayAckDist == ackDelayExponential {
+		return min + time.Duration(math.Min(faults.rand.ExpFloat64()*span/2, span))
+	}
+	return min + time.Duration(faults.rand.Float64()*span)
+}
+
+// loadListeners lists the main listener followed by Config.Listeners and Config.ListenerSpecs, with names filled
+func loadListeners(config Config) ([]ListenerConfig, error) {
+	listeners := make([]ListenerConfig, 0, 1+len(config.Listeners)+len(config.ListenerSpecs))
+	listeners = append(listeners, config.ListenerConfig)
+	listeners = append(listeners, config.Listeners...)
+	for _, spec := range config.ListenerSpecs {
+		listener, err := parseListenerSpec(spec, config.ListenerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", spec, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	names := make(map[string]bool, len(listeners))
+	for i := range listeners {
+		if listeners[i].Name == "" {
+			if i == 0 {
+				listeners[i].Name = "main"
+			} else {
+				listeners[i].Name = fmt.Sprintf("listener%d", i)
+			}
+		}
+		if names[listeners[i].Name] {
+			return nil, fmt.Errorf("duplicate name '%s'", listeners[i].Name)
+		}
+		names[listeners[i].Name] = true
+	}
+	return listeners, nil
+}
+
+// parseListenerSpec parses a listener from whitespace-separated KEY=VALUE, e.g. "address=:24225 tls=true", on top of
+// the given base listener
+//
+// Keys are the flag names of ListenerConfig fields. Name is not inherited and address must be set.
+func parseListenerSpec(spec string, base ListenerConfig) (ListenerConfig, error) {
+	listener := base
+	listener.Name = ""
+	listener.Address = ""
+	listenerValue := reflect.ValueOf(&listener).Elem()
+	for _, pair := range strings.Fields(spec) {
+		key, text, found := strings.Cut(pair, "=")
+		if !found {
+			return listener, fmt.Errorf("missing '=' in '%s'", pair)
+		}
+		field, fieldFound := findListenerField(listenerValue, key)
+		if !fieldFound {
+			return listener, fmt.Errorf("unknown key '%s'", key)
+		}
+		if err := setListenerField(field, text); err != nil {
+			return listener, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if listener.Address == "" {
+		return listener, errors.New("missing address")
+	}
+	return listener, nil
+}
+
+// findListenerField finds the field of ListenerConfig by flag name, same as gotils config
+func findListenerField(listenerValue reflect.Value, key string) (reflect.Value, bool) {
+	listenerType := listenerValue.Type()
+	for n := 0; n < listenerType.NumField(); n++ {
+		name, _ := listenerType.Field(n).Tag.Lookup("name")
+		if name == "" {
+			name = strcase.ToSnake(listenerType.Field(n).Name)
+		}
+		if name == key {
+			return listenerValue.Field(n), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func setListenerField(field reflect.Value, text string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(text)
+	case bool:
+		value, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	case float64:
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(value)
+	case time.Duration:
+		value, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(value))
+	case []string:
+		var values []string
+		if text != "" {
+			values = strings.Split(text, "|")
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
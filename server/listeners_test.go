@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
+	"github.com/relex/fluentlib/server/receivers"
+	"github.com/relex/gotils/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerListeners(t *testing.T) {
+	recv := &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+		Listeners: []ListenerConfig{
+			{
+				Name:    "secure",
+				Address: "localhost:0",
+				Secret:  "hi",
+				TLS:     true,
+			},
+		},
+		ListenerSpecs: []string{"listener_name=drop address=localhost:0 random_kill_conn=1.0"},
+	}, recv)
+	assert.Equal(t, srvAddr, srv.ListenerAddr("main"))
+	assert.Nil(t, srv.ListenerAddr("unknown"))
+
+	send := func(listener string, sharedKey string, useTLS bool) error {
+		return sendTestEvent(t, forwardprotocol.ClientConfig{
+			Address:   srv.ListenerAddr(listener).String(),
+			SharedKey: sharedKey,
+			TLS:       useTLS,
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		}, "hello-"+listener, map[string]interface{}{"field1": "foo"})
+	}
+
+	assert.Nil(t, send("main", "", false))
+	msg := <-recv.ch
+	assert.Equal(t, "main", msg.Listener)
+	assert.Equal(t, "hello-main", msg.Tag)
+
+	assert.Nil(t, send("secure", "hi", true))
+	msg = <-recv.ch
+	assert.Equal(t, "secure", msg.Listener)
+	assert.Equal(t, "hello-secure", msg.Tag)
+	assert.Error(t, send("secure", "", false), "plain connection to TLS listener")
+
+	assert.Error(t, send("drop", "", false), "connection killed before ack")
+	assert.Len(t, recv.ch, 0)
+
+	srv.Shutdown(context.Background())
+}
+
+func TestLoadListeners(t *testing.T) {
+	listeners, err := loadListeners(Config{
+		ListenerConfig: ListenerConfig{
+			Address:   "localhost:24224",
+			Secret:    "hi",
+			TLS:       true,
+			Heartbeat: true,
+		},
+		Listeners: []ListenerConfig{{Address: "localhost:24225"}},
+		ListenerSpecs: []string{
+			"listener_name=plain address=localhost:24226  tls=false secret=",
+			"address=unix:///tmp/fluent.sock users=alice:a|bob:b heartbeat_delay=1s random_fail_auth=0.5",
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []ListenerConfig{
+		{Name: "main", Address: "localhost:24224", Secret: "hi", TLS: true, Heartbeat: true},
+		{Name: "listener1", Address: "localhost:24225"},
+		{Name: "plain", Address: "localhost:24226", Secret: "", TLS: false, Heartbeat: true},
+		{
+			Name:           "listener3",
+			Address:        "unix:///tmp/fluent.sock",
+			Secret:         "hi",
+			TLS:            true,
+			Heartbeat:      true,
+			Users:          []string{"alice:a", "bob:b"},
+			HeartbeatDelay: time.Second,
+			RandomFailAuth: 0.5,
+		},
+	}, listeners)
+
+	errorSpecs := map[string]string{
+		"tls=false":                            "missing address",
+		"address=:1 tls":                       "missing '=' in 'tls'",
+		"address=:1 port=2":                    "unknown key 'port'",
+		"address=:1 heartbeat=maybe":           "heartbeat: ",
+		"address=:1 listener_name=main":        "duplicate name 'main'",
+		"address=:1 random_kill_conn=sometime": "random_kill_conn: ",
+	}
+	for spec, expectedErr := range errorSpecs {
+		_, err := loadListeners(Config{ListenerSpecs: []string{spec}})
+		assert.ErrorContains(t, err, expectedErr, spec)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package server
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/relex/fluentlib/server/receivers"
+)
+
+// getPeerCredentials returns the credentials of client process on Unix socket, or nil for other connections
+func getPeerCredentials(conn net.Conn) (*receivers.PeerCredentials, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, nil
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *syscall.Ucred
+	var ucredErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		ucred, ucredErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if ucredErr != nil {
+		return nil, ucredErr
+	}
+	return &receivers.PeerCredentials{
+		PID: ucred.Pid,
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+	}, nil
+}
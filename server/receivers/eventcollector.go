@@ -22,15 +22,14 @@ func NewEventCollector(timeout time.Duration) (Receiver, chan forwardprotocol.Ev
 func (w *eventCollector) Accept(message ClientMessage) error {
 	t := time.After(w.timeout)
 
-	for _, evt := range message.Entries {
+	return message.ForEachEntry(func(evt forwardprotocol.EventEntry) error {
 		select {
 		case w.ch <- evt:
-			// ok
+			return nil
 		case <-t:
 			return errors.New("timeout writing to event channel")
 		}
-	}
-	return nil
+	})
 }
 
 func (w *eventCollector) Tick() error {
@@ -7,7 +7,7 @@ import (
 
 // Receiver is the interface to receive decoded Fluentd forward messages from ForwardServer
 //
-// Each message may contain one or more log events
+// Each message may contain one or more log events, which may be lazy and should be read by Message.ForEachEntry
 //
 // Receiver is used from a single goroutine only
 type Receiver interface {
@@ -24,6 +24,17 @@ type Receiver interface {
 
 // ClientMessage represents a Fluentd forward message received from a client
 type ClientMessage struct {
-	ConnectionID int64
+	ConnectionID  int64
+	Listener      string           // Name of the listener which received the message
+	Tenant        string           // Tenant of the shared key used by client, or empty if it's the default
+	ClientSubject string           // Subject of the verified client certificate in mutual TLS, or empty
+	Peer          *PeerCredentials // Credentials of client process on Unix socket (Linux only), or nil
 	forwardprotocol.Message
 }
+
+// PeerCredentials contains the credentials of client process connected over Unix socket
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
@@ -17,7 +17,7 @@ type splittingFileWriter struct {
 	pathFormat    string
 	strict        bool
 	connIDToTitle map[int64]string       // connection ID to the title of the latest log event
-	titleToOutput map[string]splitOutput // title to file; title is "tag-key1,key2,key3,..."
+	titleToOutput map[string]splitOutput // title to file; title is "[tenant-]tag-key1,key2,key3,..."
 }
 
 type splitOutput struct {
@@ -37,7 +37,8 @@ func VerifySplittingFilePath(pathFormat string) error {
 //
 // Each output file is a valid JSON itself, as an array of log events
 //
-// pathFormat must contain a "%s", which would be replaced by "tag-key1,key2,key3,..."
+// pathFormat must contain a "%s", which would be replaced by "tag-key1,key2,key3,...", prefixed by "tenant-" if the
+// client used a tenant's shared key
 //
 // strict mode means each connection may only send log events of the same key field set, or an error would be logged
 func NewSplittingFileWriter(keys []string, pathFormat string, strict bool) Receiver {
@@ -55,17 +56,9 @@ func NewSplittingFileWriter(keys []string, pathFormat string, strict bool) Recei
 }
 
 func (w *splittingFileWriter) Accept(message ClientMessage) error {
-	if len(message.Entries) == 0 {
-		return nil
-	}
-
-	for _, event := range message.Entries {
-		if err := w.acceptEvent(event, message.Tag, message.ConnectionID); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return message.ForEachEntry(func(event forwardprotocol.EventEntry) error {
+		return w.acceptEvent(event, message.Tag, message.Tenant, message.ConnectionID)
+	})
 }
 
 func (w *splittingFileWriter) Tick() error {
@@ -93,8 +86,11 @@ func (w *splittingFileWriter) End() error {
 	return nil
 }
 
-func (w *splittingFileWriter) acceptEvent(event forwardprotocol.EventEntry, tag string, connID int64) error {
+func (w *splittingFileWriter) acceptEvent(event forwardprotocol.EventEntry, tag string, tenant string, connID int64) error {
 	title := w.makeEventTitle(event, tag)
+	if len(tenant) > 0 {
+		title = strings.ReplaceAll(tenant, "/", "_") + "-" + title
+	}
 
 	isFirst := false
 	var output splitOutput
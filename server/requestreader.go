@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var errDraining = errors.New("server is shutting down")
+
+// requestReader reads requests from connection, with idle timeout until the next request arrives and then read timeout
+// until it's read completely
+//
+// Reading stops once the server is draining. It's checked after every deadline is set, so that the interruption from
+// Shutdown cannot be overridden.
+type requestReader struct {
+	conn        net.Conn
+	draining    context.Context
+	idleTimeout time.Duration
+	readTimeout time.Duration
+	reading     bool
+}
+
+func (r *requestReader) Read(p []byte) (int, error) {
+	if !r.reading {
+		if err := r.setTimeout(r.idleTimeout); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.conn.Read(p)
+	if n > 0 && !r.reading {
+		r.reading = true
+		if timeoutErr := r.setTimeout(r.readTimeout); timeoutErr != nil && err == nil {
+			err = timeoutErr
+		}
+	}
+	return n, err
+}
+
+// next marks the end of a request, so that the following read waits for a new one with idle timeout
+//
+// Requests read ahead by decoders are not counted, so the actual wait could be longer.
+func (r *requestReader) next() {
+	r.reading = false
+}
+
+// idle checks whether the reader is waiting for a new request
+func (r *requestReader) idle() bool {
+	return !r.reading
+}
+
+func (r *requestReader) setTimeout(timeout time.Duration) error {
+	if err := r.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return fmt.Errorf("set read timeout: %w", err)
+	}
+	if r.draining.Err() != nil {
+		return errDraining
+	}
+	return nil
+}
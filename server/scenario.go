@@ -0,0 +1,247 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scenario actions for ScenarioRule.Action
+const (
+	scenarioDown         = "down"          // Close new connections immediately and existing ones at their next messages
+	scenarioNoHandshake  = "no_handshake"  // Keep connection open without handshaking for the stall of random_no_handshake
+	scenarioFailAuth     = "fail_auth"     // Reject client in handshake
+	scenarioNoReceiving  = "no_receiving"  // Stop reading for the stall of random_no_receiving before the message
+	scenarioKillConn     = "kill_conn"     // Close connection after reading the message, without passing it to receiver
+	scenarioNoAck        = "no_ack"        // Pass the message to receiver without acking it
+	scenarioWrongAck     = "wrong_ack"     // Ack the message with a wrong chunk ID
+	scenarioDuplicateAck = "duplicate_ack" // Ack the message twice
+	scenarioReorderAck   = "reorder_ack"   // Hold the ack of the message until the next ack is sent
+	scenarioPhantomAck   = "phantom_ack"   // Ack a chunk never received before acking the message
+	scenarioMalformedAck = "malformed_ack" // Respond the message with a malformed ack map
+	scenarioDelayAck     = "delay_ack"     // Delay the ack of the message by random_delay_ack_min to max
+)
+
+// ScenarioRule is a scripted fault applied when all of its conditions match, for deterministic testing
+//
+// Ranges are 1-based and inclusive, as "N", "N-M" or "N-" (no upper bound). Empty conditions match anything.
+// Connection-level actions (no_handshake, fail_auth) never match rules with message conditions. Down is checked for
+// new connections and again after each message is read, so rules with message conditions close at that message.
+// TotalMessages cannot be used with no_receiving, which is checked before the message is read and counted.
+type ScenarioRule struct {
+	Action        string `json:"action"`                   // Action, e.g. down, fail_auth, kill_conn, no_ack or wrong_ack
+	Listener      string `json:"listener,omitempty"`       // Name of listener
+	Connections   string `json:"connections,omitempty"`    // Range of connections in the order accepted by server
+	Messages      string `json:"messages,omitempty"`       // Range of messages in the order received on each connection
+	TotalMessages string `json:"total_messages,omitempty"` // Range of messages in the order received by server
+	From          string `json:"from,omitempty"`           // Start time since the server started serving, e.g. "30s"
+	To            string `json:"to,omitempty"`             // End time (exclusive) since the server started serving, e.g. "1m"
+}
+
+// scenario is a list of compiled ScenarioRule
+type scenario struct {
+	rules []scenarioRule
+}
+
+type scenarioRule struct {
+	action        string
+	listener      string
+	connections   scenarioRange
+	messages      scenarioRange
+	totalMessages scenarioRange
+	from          time.Duration
+	to            time.Duration // 0 for no end
+}
+
+// scenarioRange is an inclusive range of 1-based sequence numbers, where zero values mean no bound
+type scenarioRange struct {
+	min int64
+	max int64
+}
+
+// scenarioEvent describes a connection or message for scenario rules
+type scenarioEvent struct {
+	listener     string
+	connection   int64
+	message      int64 // 0 for connection-level events
+	totalMessage int64 // 0 for connection-level events
+	elapsed      time.Duration
+}
+
+// loadScenario compiles rules from Config.Scenario followed by the ones in Config.ScenarioFile
+func loadScenario(config Config) (*scenario, error) {
+	rules := config.Scenario
+	if len(config.ScenarioFile) > 0 {
+		content, err := os.ReadFile(config.ScenarioFile)
+		if err != nil {
+			return nil, err
+		}
+		var fileRules []ScenarioRule
+		if err := json.Unmarshal(content, &fileRules); err != nil {
+			return nil, fmt.Errorf("%s: %w", config.ScenarioFile, err)
+		}
+		rules = append(append([]ScenarioRule{}, rules...), fileRules...)
+	}
+	compiled := make([]scenarioRule, 0, len(rules))
+	for i, rule := range rules {
+		c, err := compileScenarioRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+		}
+		compiled = append(compiled, c)
+	}
+	return &scenario{compiled}, nil
+}
+
+func compileScenarioRule(rule ScenarioRule) (scenarioRule, error) {
+	compiled := scenarioRule{
+		action:        rule.Action,
+		listener:      rule.Listener,
+		connections:   scenarioRange{},
+		messages:      scenarioRange{},
+		totalMessages: scenarioRange{},
+		from:          0,
+		to:            0,
+	}
+	switch rule.Action {
+	case scenarioDown, scenarioNoHandshake, scenarioFailAuth, scenarioNoReceiving, scenarioKillConn, scenarioNoAck,
+		scenarioWrongAck, scenarioDuplicateAck, scenarioReorderAck, scenarioPhantomAck, scenarioMalformedAck,
+		scenarioDelayAck:
+	default:
+		return compiled, fmt.Errorf("unknown action '%s'", rule.Action)
+	}
+	var err error
+	if compiled.connections, err = parseScenarioRange(rule.Connections); err != nil {
+		return compiled, fmt.Errorf("connections: %w", err)
+	}
+	if compiled.messages, err = parseScenarioRange(rule.Messages); err != nil {
+		return compiled, fmt.Errorf("messages: %w", err)
+	}
+	if compiled.totalMessages, err = parseScenarioRange(rule.TotalMessages); err != nil {
+		return compiled, fmt.Errorf("total_messages: %w", err)
+	}
+	if rule.Action == scenarioNoReceiving && rule.TotalMessages != "" {
+		// the total is unknown before reading, as messages from concurrent connections are counted on arrival
+		return compiled, errors.New("total_messages: not available for no_receiving")
+	}
+	if len(rule.From) > 0 {
+		if compiled.from, err = time.ParseDuration(rule.From); err != nil {
+			return compiled, fmt.Errorf("from: %w", err)
+		}
+	}
+	if len(rule.To) > 0 {
+		if compiled.to, err = time.ParseDuration(rule.To); err != nil {
+			return compiled, fmt.Errorf("to: %w", err)
+		}
+	}
+	return compiled, nil
+}
+
+// parseScenarioRange parses "N", "N-M" or "N-", or empty for any
+func parseScenarioRange(text string) (scenarioRange, error) {
+	if text == "" {
+		return scenarioRange{}, nil
+	}
+	minText, maxText, isRange := strings.Cut(text, "-")
+	min, err := strconv.ParseInt(minText, 10, 64)
+	if err != nil || min < 1 {
+		return scenarioRange{}, fmt.Errorf("invalid start in '%s'", text)
+	}
+	if !isRange {
+		return scenarioRange{min, min}, nil
+	}
+	if maxText == "" {
+		return scenarioRange{min, 0}, nil
+	}
+	max, err := strconv.ParseInt(maxText, 10, 64)
+	if err != nil || max < min {
+		return scenarioRange{}, fmt.Errorf("invalid end in '%s'", text)
+	}
+	return scenarioRange{min, max}, nil
+}
+
+// match checks whether any rule of the given action matches the event
+func (s *scenario) match(action string, event scenarioEvent) bool {
+	for _, rule := range s.rules {
+		if rule.action == action && rule.match(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule scenarioRule) match(event scenarioEvent) bool {
+	if rule.listener != "" && rule.listener != event.listener {
+		return false
+	}
+	if event.elapsed < rule.from || (rule.to > 0 && event.elapsed >= rule.to) {
+		return false
+	}
+	return rule.connections.contains(event.connection) &&
+		rule.messages.contains(event.message) &&
+		rule.totalMessages.contains(event.totalMessage)
+}
+
+func (r scenarioRange) contains(seq int64) bool {
+	if r.min == 0 && r.max == 0 {
+		return true
+	}
+	return seq >= r.min && (r.max == 0 || seq <= r.max)
+}
+
+// newRand creates a random generator for the given stream from the seed of server, so that random faults of each
+// connection and heartbeat listener are reproducible regardless of the timing of others
+func newRand(seed int64, stream int64) *rand.Rand {
+	return rand.New(rand.NewSource(int64(uint64(seed) ^ uint64(stream)*0x9E3779B97F4A7C15)))
+}
+
+// newSeed picks a seed for Config.RandomSeed of zero
+func newSeed() int64 {
+	for {
+		if seed := rand.Int63(); seed != 0 {
+			return seed
+		}
+	}
+}
+
+// connFaults decides the scripted and random faults of a connection
+type connFaults struct {
+	rand      *rand.Rand
+	scenario  *scenario
+	startTime time.Time
+	event     scenarioEvent
+}
+
+// check checks whether the fault of action should be applied to the current connection or message, by scenario or
+// else by the given chance
+//
+// Returns (apply?, reason for logging)
+func (f *connFaults) check(action string, chance float64) (bool, string) {
+	event := f.event
+	event.elapsed = time.Since(f.startTime)
+	if f.scenario.match(action, event) {
+		return true, "by scenario"
+	}
+	return f.random(chance)
+}
+
+// random checks whether a fault without scenario action should be applied by the given chance
+//
+// Returns (apply?, reason for logging)
+func (f *connFaults) random(chance float64) (bool, string) {
+	if r := f.rand.Float64(); r < chance {
+		return true, fmt.Sprintf("by random chance: %f", r)
+	}
+	return false, ""
+}
+
+// setMessage sets the current message by its sequence numbers on connection and on server, or zeros for none
+func (f *connFaults) setMessage(message int64, totalMessage int64) {
+	f.event.message = message
+	f.event.totalMessage = totalMessage
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
+	"github.com/relex/fluentlib/server/receivers"
+	"github.com/relex/gotils/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadScenario(t *testing.T) {
+	dirPath, dirErr := os.MkdirTemp("", "fluentlib-scenario-test-*")
+	assert.Nil(t, dirErr)
+	defer os.RemoveAll(dirPath)
+
+	scenarioFile := filepath.Join(dirPath, "scenario.json")
+	assert.Nil(t, os.WriteFile(scenarioFile, []byte(`[
+		{"action": "kill_conn", "messages": "5"},
+		{"action": "no_ack", "total_messages": "10-20"},
+		{"action": "down", "from": "30s", "to": "1m"}
+	]`), 0600))
+	s, err := loadScenario(Config{
+		Scenario:     []ScenarioRule{{Action: "fail_auth", Listener: "main", Connections: "1-2"}},
+		ScenarioFile: scenarioFile,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []scenarioRule{
+		{action: "fail_auth", listener: "main", connections: scenarioRange{1, 2}},
+		{action: "kill_conn", messages: scenarioRange{5, 5}},
+		{action: "no_ack", totalMessages: scenarioRange{10, 20}},
+		{action: "down", from: 30 * time.Second, to: time.Minute},
+	}, s.rules)
+
+	assert.True(t, s.match("fail_auth", scenarioEvent{listener: "main", connection: 2}))
+	assert.False(t, s.match("fail_auth", scenarioEvent{listener: "main", connection: 3}))
+	assert.False(t, s.match("fail_auth", scenarioEvent{listener: "other", connection: 1}))
+	assert.True(t, s.match("kill_conn", scenarioEvent{connection: 7, message: 5, totalMessage: 9}))
+	assert.False(t, s.match("kill_conn", scenarioEvent{connection: 7}), "connection-level event")
+	assert.True(t, s.match("no_ack", scenarioEvent{message: 1, totalMessage: 20}))
+	assert.False(t, s.match("no_ack", scenarioEvent{message: 1, totalMessage: 21}))
+	assert.False(t, s.match("down", scenarioEvent{connection: 1, elapsed: 29 * time.Second}))
+	assert.True(t, s.match("down", scenarioEvent{connection: 1, elapsed: 30 * time.Second}))
+	assert.False(t, s.match("down", scenarioEvent{connection: 1, elapsed: time.Minute}))
+
+	// down is checked for connections and messages
+	s, err = loadScenario(Config{Scenario: []ScenarioRule{{Action: "down", Messages: "2"}}})
+	assert.Nil(t, err)
+	assert.False(t, s.match("down", scenarioEvent{connection: 1}))
+	assert.True(t, s.match("down", scenarioEvent{connection: 1, message: 2, totalMessage: 2}))
+
+	errorRules := map[string]ScenarioRule{
+		"rule #1: unknown action 'explode'":       {Action: "explode"},
+		"rule #1: connections: invalid start":     {Action: "down", Connections: "0"},
+		"rule #1: messages: invalid end in '5-3'": {Action: "kill_conn", Messages: "5-3"},
+		"rule #1: total_messages: invalid start":  {Action: "no_ack", TotalMessages: "x-"},
+		"rule #1: from: ":                         {Action: "down", From: "soon"},
+		"rule #1: total_messages: not available":  {Action: "no_receiving", TotalMessages: "3"},
+	}
+	for expectedErr, rule := range errorRules {
+		_, err := loadScenario(Config{Scenario: []ScenarioRule{rule}})
+		assert.ErrorContains(t, err, expectedErr)
+	}
+	_, err = loadScenario(Config{ScenarioFile: filepath.Join(dirPath, "nonexistent.json")})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestServerScenario(t *testing.T) {
+	recv := &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+		Listeners: []ListenerConfig{
+			{
+				Name:    "auth",
+				Address: "localhost:0",
+				Secret:  "hi",
+			},
+		},
+		Scenario: []ScenarioRule{
+			{Action: "down", Connections: "1"},
+			{Action: "no_ack", Listener: "main", Messages: "2"},
+			{Action: "kill_conn", Listener: "main", Messages: "3"},
+			{Action: "fail_auth", Listener: "auth", Connections: "3"},
+		},
+		ReadTimeout: time.Second,
+	}, recv)
+	defer srv.Shutdown(context.Background())
+
+	// 1st connection: down
+	conn1, err := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, err)
+	defer conn1.Close()
+	_, err = conn1.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// 2nd connection: ack, no ack, killed
+	conn2, err := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, err)
+	defer conn2.Close()
+	jdecoder := json.NewDecoder(conn2)
+	send := func(chunkID string) (forwardprotocol.Ack, error) {
+		_, err := conn2.Write([]byte(`["hello", 1604106123, {"field1": "foo"}, {"chunk": "` + chunkID + `"}]`))
+		assert.Nil(t, err)
+		assert.Nil(t, conn2.SetReadDeadline(time.Now().Add(300*time.Millisecond)))
+		ack := forwardprotocol.Ack{}
+		return ack, jdecoder.Decode(&ack)
+	}
+	ack, err := send("c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "c1", ack.Ack)
+	_, err = send("c2")
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "no ack")
+	_, err = conn2.Write([]byte(`["hello", 1604106123, {"field1": "foo"}, {"chunk": "c3"}]`))
+	assert.Nil(t, err)
+	assert.Nil(t, conn2.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn2.Read(make([]byte, 1)) // the json decoder keeps the timeout error from above
+	assert.ErrorIs(t, err, io.EOF, "killed")
+	assert.Equal(t, "c1", (<-recv.ch).Option.Chunk)
+	assert.Equal(t, "c2", (<-recv.ch).Option.Chunk)
+	assert.Len(t, recv.ch, 0)
+
+	// 3rd connection: auth failure, and then 4th: OK
+	sendAuth := func() error {
+		return sendTestEvent(t, forwardprotocol.ClientConfig{
+			Address:   srv.ListenerAddr("auth").String(),
+			SharedKey: "hi",
+		}, "hello-auth", map[string]interface{}{"field1": "foo"})
+	}
+	err = sendAuth()
+	assert.True(t, errors.Is(err, forwardprotocol.ErrAuthRejected), err)
+	assert.Nil(t, sendAuth())
+	assert.Equal(t, "hello-auth", (<-recv.ch).Tag)
+}
+
+func TestRandomSeed(t *testing.T) {
+	sequence := func(seed int64, stream int64) []float64 {
+		faults := &connFaults{rand: newRand(seed, stream), scenario: &scenario{}}
+		values := make([]float64, 0, 10)
+		for i := 0; i < 10; i++ {
+			faults.random(0)
+			values = append(values, faults.rand.Float64())
+		}
+		return values
+	}
+	assert.Equal(t, sequence(42, 1), sequence(42, 1))
+	assert.NotEqual(t, sequence(42, 1), sequence(42, 2))
+	assert.NotEqual(t, sequence(42, 1), sequence(43, 1))
+}
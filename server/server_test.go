@@ -2,10 +2,18 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -22,9 +30,11 @@ import (
 func TestServerBasic(t *testing.T) {
 	recv, ch := receivers.NewEventCollector(5 * time.Second)
 	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
-		Address: "localhost:0",
-		Secret:  "hi",
-		TLS:     true,
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+			Secret:  "hi",
+			TLS:     true,
+		},
 	}, recv)
 
 	conn, connErr := openConn(srvAddr.String(), "hi")
@@ -66,46 +76,677 @@ func TestServerBasic(t *testing.T) {
 	assert.Equal(t, request.Entries[1].Time.Format(time.RFC3339Nano), msg2.Time.UTC().Format(time.RFC3339Nano))
 	assert.Equal(t, request.Entries[1].Record, msg2.Record)
 
-	srv.Shutdown()
+	srv.Shutdown(context.Background())
+}
+
+func TestServerWithClient(t *testing.T) {
+	recv, ch := receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+			Secret:  "hi",
+			TLS:     true,
+		},
+	}, recv)
+
+	entry := forwardprotocol.EventEntry{
+		Time:   forwardprotocol.EventTime{Time: time.Date(2020, 10, 31, 1, 2, 3, 4, time.UTC)},
+		Record: map[string]interface{}{"field1": "foo"},
+	}
+	for _, mode := range []forwardprotocol.MessageMode{forwardprotocol.ModeMessage, forwardprotocol.ModeForward, forwardprotocol.ModePackedForward, forwardprotocol.ModeCompressedPackedForward} {
+		client := forwardprotocol.NewForwardClient(logger.WithField("test", t.Name()), forwardprotocol.ClientConfig{
+			Address:    srvAddr.String(),
+			TLS:        true,
+			TLSConfig:  &tls.Config{InsecureSkipVerify: true},
+			SharedKey:  "hi",
+			Mode:       mode,
+			RequireAck: true,
+		})
+		assert.Nil(t, client.Send("hello", []forwardprotocol.EventEntry{entry}), mode)
+		client.Close()
+
+		msg := <-ch
+		assert.True(t, entry.Time.Equal(msg.Time.Time), mode)
+		assert.Equal(t, entry.Record, msg.Record, mode)
+	}
+
+	srv.Shutdown(context.Background())
+}
+
+func TestServerUserAuth(t *testing.T) {
+	recv, ch := receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+			Secret:  "hi",
+			Users:   []string{"alice:wonderland"},
+		},
+	}, recv)
+
+	makeClient := func(password string) *forwardprotocol.ForwardClient {
+		return forwardprotocol.NewForwardClient(logger.WithField("test", t.Name()), forwardprotocol.ClientConfig{
+			Address:    srvAddr.String(),
+			SharedKey:  "hi",
+			Username:   "alice",
+			Password:   password,
+			RequireAck: true,
+		})
+	}
+	entries := []forwardprotocol.EventEntry{
+		{
+			Time:   forwardprotocol.EventTime{Time: time.Date(2020, 10, 31, 1, 2, 3, 4, time.UTC)},
+			Record: map[string]interface{}{"field1": "foo"},
+		},
+	}
+
+	badClient := makeClient("looking-glass")
+	assert.ErrorContains(t, badClient.Send("hello", entries), "username/password mismatch")
+	badClient.Close()
+
+	goodClient := makeClient("wonderland")
+	assert.Nil(t, goodClient.Send("hello", entries))
+	goodClient.Close()
+
+	msg := <-ch
+	assert.Equal(t, entries[0].Record, msg.Record)
+
+	srv.Shutdown(context.Background())
+}
+
+func TestServerLimits(t *testing.T) {
+	recv, ch := receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+		LazyEntries:     true,
+		MaxEntries:      1,
+		MaxStringLength: 10,
+	}, recv)
+
+	client := forwardprotocol.NewForwardClient(logger.WithField("test", t.Name()), forwardprotocol.ClientConfig{
+		Address:    srvAddr.String(),
+		Mode:       forwardprotocol.ModeCompressedPackedForward,
+		RequireAck: true,
+	})
+	defer client.Close()
+
+	entry := forwardprotocol.EventEntry{
+		Time:   forwardprotocol.EventTime{Time: time.Date(2020, 10, 31, 1, 2, 3, 4, time.UTC)},
+		Record: map[string]interface{}{"field1": "foo"},
+	}
+	longEntry := forwardprotocol.EventEntry{
+		Time:   entry.Time,
+		Record: map[string]interface{}{"field1": "a long string"},
+	}
+
+	assert.Error(t, client.Send("hello", []forwardprotocol.EventEntry{entry, entry}))
+	assert.Error(t, client.Send("hello", []forwardprotocol.EventEntry{longEntry}))
+	assert.Nil(t, client.Send("hello", []forwardprotocol.EventEntry{entry}))
+
+	msg := <-ch
+	assert.Equal(t, entry.Record, msg.Record)
+
+	srv.Shutdown(context.Background())
+
+	// unchecked lazy entries are only decoded by the receiver, which fails for the violation
+	recv, ch = receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr = LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+		LazyEntries:          true,
+		LazyEntriesUnchecked: true,
+		MaxEntries:           1,
+	}, recv)
+	uncheckedClient := forwardprotocol.NewForwardClient(logger.WithField("test", t.Name()), forwardprotocol.ClientConfig{
+		Address:    srvAddr.String(),
+		Mode:       forwardprotocol.ModePackedForward,
+		RequireAck: true,
+	})
+	defer uncheckedClient.Close()
+
+	assert.Nil(t, uncheckedClient.Send("hello", []forwardprotocol.EventEntry{entry}))
+	msg = <-ch
+	assert.Equal(t, entry.Record, msg.Record)
+	_ = uncheckedClient.Send("hello", []forwardprotocol.EventEntry{entry, entry}) // may fail as the server stops
+	select {
+	case <-srv.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server not stopped by receiver")
+	}
+	assert.ErrorContains(t, srv.Err(), "MaxEntries exceeded: 2 > 1")
 }
 
 func TestServerFailureEmulation(t *testing.T) {
 	if util.IsTestGenerationMode() {
 		return
 	}
-	recv, ch := receivers.NewMessageCollector(5 * time.Second)
+	for _, lazy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazy=%t", lazy), func(t *testing.T) {
+			recv, ch := receivers.NewMessageCollector(5 * time.Second)
+			srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+				ListenerConfig: ListenerConfig{
+					Address:          "localhost:0",
+					Secret:           "hi",
+					TLS:              true,
+					RandomFailAuth:   0.6,
+					RandomKillConn:   0.2,
+					RandomNoResponse: 0.0, // timeout would block tests for too long
+				},
+				LazyEntries: lazy,
+				RandomSeed:  42, // some seeds fail auth more than the retries of send
+			}, recv)
+
+			var conn net.Conn
+
+			for _, fn := range testdata.ListInputFiles(t) {
+				sampleInput, sampleErr := ioutil.ReadFile(fn)
+				assert.Nil(t, sampleErr, fn)
+
+				assert.Nil(t, send(&conn, srvAddr.String(), "hi", sampleInput), fn)
+
+				expectedFn := testdata.GetOutputFilename(t, fn)
+				expected, readErr := ioutil.ReadFile(expectedFn)
+				assert.Nil(t, readErr, expectedFn)
+
+				var msg forwardprotocol.Message
+				select {
+				case msg = <-ch:
+				case <-time.After(5 * time.Second):
+					t.Fatal("no message received for ", fn)
+				}
+				assert.Equal(t, lazy, msg.IsLazy(), fn)
+				wrt := &bytes.Buffer{}
+				assert.Nil(t, dump.PrintMessageInJSON(msg, true, wrt))
+				assert.Equal(t, string(expected), wrt.String())
+			}
+
+			if conn != nil {
+				conn.Close()
+			}
+
+			srv.Shutdown(context.Background())
+		})
+	}
+}
+
+func TestServerHeartbeat(t *testing.T) {
+	recv, _ := receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address:   "localhost:0",
+			Heartbeat: true,
+		},
+	}, recv)
+
+	_, err := forwardprotocol.ProbeHeartbeat(srvAddr.String(), 5*time.Second)
+	assert.Nil(t, err)
+	srv.Shutdown(context.Background())
+
+	recv, _ = receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr = LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address:             "localhost:0",
+			Heartbeat:           true,
+			RandomDropHeartbeat: 1.0,
+		},
+	}, recv)
+
+	_, err = forwardprotocol.ProbeHeartbeat(srvAddr.String(), 100*time.Millisecond)
+	assert.ErrorContains(t, err, "i/o timeout")
+	srv.Shutdown(context.Background())
+
+	// delayed heartbeats are queued without a goroutine each, and abandoned on shutdown
+	goroutinesBefore := runtime.NumGoroutine()
+	recv, _ = receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr = LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address:        "localhost:0",
+			Heartbeat:      true,
+			HeartbeatDelay: 500 * time.Millisecond,
+		},
+	}, recv)
+	probeStart := time.Now()
+	_, err = forwardprotocol.ProbeHeartbeat(srvAddr.String(), 5*time.Second)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, time.Since(probeStart), 500*time.Millisecond)
+	udpConn, err := net.Dial("udp", srvAddr.String())
+	assert.Nil(t, err)
+	defer udpConn.Close()
+	for i := 0; i < 2*maxDelayedHeartbeats; i++ {
+		_, err = udpConn.Write([]byte{0})
+		assert.Nil(t, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	srv.Shutdown(context.Background())
+	assert.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= goroutinesBefore+1 // +1 for the goroutine of this check
+	}, 300*time.Millisecond, 10*time.Millisecond, "no heartbeat left waiting after shutdown")
+}
+
+func TestServerJSON(t *testing.T) {
+	recv, ch := receivers.NewEventCollector(5 * time.Second)
 	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
-		Address:          "localhost:0",
-		Secret:           "hi",
-		TLS:              true,
-		RandomFailAuth:   0.6,
-		RandomKillConn:   0.2,
-		RandomNoResponse: 0.0, // timeout would block tests for too long
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
 	}, recv)
 
-	var conn net.Conn
+	conn, err := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(`["hello", 1604106123, {"field1": "foo"}, {"chunk": "c1"}]` + "\n" +
+		`["hello", [[1604106124, {"field1": "bar"}]], {"chunk": "c2"}]`))
+	assert.Nil(t, err)
+
+	decoder := json.NewDecoder(conn)
+	for _, chunkID := range []string{"c1", "c2"} {
+		var ack forwardprotocol.Ack
+		assert.Nil(t, decoder.Decode(&ack))
+		assert.Equal(t, chunkID, ack.Ack)
+	}
 
-	for _, fn := range testdata.ListInputFiles(t) {
-		sampleInput, sampleErr := ioutil.ReadFile(fn)
-		assert.Nil(t, sampleErr, fn)
+	msg1 := <-ch
+	assert.Equal(t, int64(1604106123), msg1.Time.Unix())
+	assert.Equal(t, map[string]interface{}{"field1": "foo"}, msg1.Record)
+	msg2 := <-ch
+	assert.Equal(t, map[string]interface{}{"field1": "bar"}, msg2.Record)
 
-		assert.Nil(t, send(&conn, srvAddr.String(), "hi", sampleInput), fn)
+	srv.Shutdown(context.Background())
+}
 
-		expectedFn := testdata.GetOutputFilename(t, fn)
-		expected, readErr := ioutil.ReadFile(expectedFn)
-		assert.Nil(t, readErr, expectedFn)
+func TestServerJSONLimits(t *testing.T) {
+	recv, ch := receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+		MaxMessageBytes: 1000,
+	}, recv)
+	defer srv.Shutdown(context.Background())
 
-		msg := <-ch
-		wrt := &bytes.Buffer{}
-		assert.Nil(t, dump.PrintMessageInJSON(msg, true, wrt))
-		assert.Equal(t, string(expected), wrt.String())
+	conn, err := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// the message never ends, so the connection can only be closed by the limit
+	go func() {
+		if _, err := conn.Write([]byte(`["hello", 1604106123, {"field1": "`)); err != nil {
+			return
+		}
+		filler := bytes.Repeat([]byte{'x'}, 4096)
+		for {
+			if _, err := conn.Write(filler); err != nil {
+				return
+			}
+		}
+	}()
+	assert.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, os.ErrDeadlineExceeded), "connection should be closed for exceeding limit")
+	assert.Len(t, ch, 0)
+}
+
+func TestServerTenants(t *testing.T) {
+	dirPath, dirErr := os.MkdirTemp("", "fluentlib-tenant-test-*")
+	assert.Nil(t, dirErr)
+	defer os.RemoveAll(dirPath)
+
+	recv := receivers.NewSplittingFileWriter(nil, filepath.Join(dirPath, "%s.json"), false)
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+			Tenants: []string{"fleet-a:key-a", "fleet-b:key-b"},
+		},
+	}, recv)
+
+	send := func(sharedKey string) error {
+		return sendTestEvent(t, forwardprotocol.ClientConfig{
+			Address:   srvAddr.String(),
+			SharedKey: sharedKey,
+		}, "hello", map[string]interface{}{"key": sharedKey})
+	}
+	assert.Nil(t, send("key-a"))
+	assert.Nil(t, send("key-b"))
+	assert.ErrorContains(t, send("key-c"), "shared_key mismatch")
+
+	srv.Shutdown(context.Background())
+
+	for _, tenant := range []string{"fleet-a", "fleet-b"} {
+		output, readErr := os.ReadFile(filepath.Join(dirPath, tenant+"-hello.json"))
+		assert.Nil(t, readErr, tenant)
+		assert.Contains(t, string(output), strings.Replace(tenant, "fleet", "key", 1), tenant)
+	}
+}
+
+func TestServerClientRules(t *testing.T) {
+	recv, ch := receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address:             "localhost:0",
+			Secret:              "hi",
+			ClientRules:         []string{"network=10.0.0.0/8;shared_key=remote", "network=127.0.0.0/8;host=allowed-*;shared_key=local"},
+			DenyAnonymousSource: true,
+		},
+	}, recv)
+
+	send := func(hostname string, sharedKey string) error {
+		return sendTestEvent(t, forwardprotocol.ClientConfig{
+			Address:        srvAddr.String(),
+			SharedKey:      sharedKey,
+			ClientHostname: hostname,
+		}, "hello", map[string]interface{}{"host": hostname})
+	}
+	assert.ErrorContains(t, send("allowed-1", "hi"), "shared_key mismatch")
+	assert.ErrorContains(t, send("denied-1", "hi"), "anonymous source host")
+	assert.Nil(t, send("allowed-1", "local"))
+
+	msg := <-ch
+	assert.Equal(t, map[string]interface{}{"host": "allowed-1"}, msg.Record)
+
+	srv.Shutdown(context.Background())
+
+	// anonymous sources are accepted by default, same as Fluentd
+	recv, ch = receivers.NewEventCollector(5 * time.Second)
+	srv, srvAddr = LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address:     "localhost:0",
+			Secret:      "hi",
+			ClientRules: []string{"network=127.0.0.0/8;host=allowed-*;shared_key=local"},
+		},
+	}, recv)
+	assert.Nil(t, send("denied-1", "hi"))
+	msg = <-ch
+	assert.Equal(t, map[string]interface{}{"host": "denied-1"}, msg.Record)
+
+	srv.Shutdown(context.Background())
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	dirPath, dirErr := os.MkdirTemp("", "fluentlib-unix-test-*")
+	assert.Nil(t, dirErr)
+	defer os.RemoveAll(dirPath)
+
+	socketPath := filepath.Join(dirPath, "fluent.sock")
+	staleListener, staleErr := net.Listen("unix", socketPath)
+	assert.Nil(t, staleErr)
+	staleListener.(*net.UnixListener).SetUnlinkOnClose(false)
+	staleListener.Close()
+
+	addresses := []string{forwardprotocol.UnixAddressPrefix + socketPath}
+	if runtime.GOOS == "linux" {
+		addresses = append(addresses, fmt.Sprintf("%s@fluentlib-test-%d", forwardprotocol.UnixAddressPrefix, os.Getpid()))
+	}
+	for _, address := range addresses {
+		recv := &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+		srv, _ := LaunchServer(logger.WithField("test", t.Name()), Config{
+			ListenerConfig: ListenerConfig{
+				Address:        address,
+				UnixSocketMode: "0600",
+				Secret:         "hi",
+				Heartbeat:      true,
+			},
+		}, recv)
+
+		assert.Nil(t, sendTestEvent(t, forwardprotocol.ClientConfig{
+			Address:   address,
+			SharedKey: "hi",
+		}, "hello", map[string]interface{}{"field1": "foo"}), address)
+
+		msg := <-recv.ch
+		assert.Equal(t, "hello", msg.Tag, address)
+		if runtime.GOOS == "linux" {
+			assert.Equal(t, &receivers.PeerCredentials{
+				PID: int32(os.Getpid()),
+				UID: uint32(os.Getuid()),
+				GID: uint32(os.Getgid()),
+			}, msg.Peer, address)
+		}
+		srv.Shutdown(context.Background())
+	}
+
+	// the first server should have replaced the stale socket file and set the mode, and the file is removed on close
+	_, statErr := os.Stat(socketPath)
+	assert.ErrorIs(t, statErr, os.ErrNotExist)
+
+	srv, _ := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address:        addresses[0],
+			UnixSocketMode: "0600",
+		},
+	}, &clientMessageCollector{make(chan receivers.ClientMessage, 10)})
+	info, statErr := os.Stat(socketPath)
+	assert.Nil(t, statErr)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.Panics(t, func() {
+		LaunchServer(logger.WithField("test", t.Name()), Config{ListenerConfig: ListenerConfig{Address: addresses[0]}}, &clientMessageCollector{nil})
+	}, "socket in use")
+	srv.Shutdown(context.Background())
+}
+
+// failingReceiver is a Receiver which fails to accept any message
+type failingReceiver struct {
+	ended chan struct{}
+}
+
+func (r *failingReceiver) Accept(message receivers.ClientMessage) error {
+	return errors.New("disk full")
+}
+
+func (r *failingReceiver) Tick() error {
+	return nil
+}
+
+func (r *failingReceiver) End() error {
+	close(r.ended)
+	return nil
+}
+
+func TestServerLifecycle(t *testing.T) {
+	_, err := NewServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:-1",
+		},
+	}, &clientMessageCollector{nil})
+	assert.ErrorContains(t, err, "listener 'main': listen: ")
+
+	// Shutdown drains messages and acks, and interrupts idle connections without waiting for timeout
+	recv := &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+	srv, err := NewServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+	}, recv)
+	assert.Nil(t, err)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(context.Background()) }()
+
+	conn, dialErr := net.Dial("tcp", srv.Addr().String())
+	assert.Nil(t, dialErr)
+	defer conn.Close()
+	_, err = conn.Write([]byte(`["hello", 1604106123, {"field1": "foo"}, {"chunk": "c1"}]`))
+	assert.Nil(t, err)
+	ack := forwardprotocol.Ack{}
+	assert.Nil(t, json.NewDecoder(conn).Decode(&ack))
+	assert.Equal(t, "c1", ack.Ack)
+	assert.ErrorContains(t, srv.Serve(context.Background()), "already started")
+
+	shutdownStart := time.Now()
+	assert.Nil(t, srv.Shutdown(context.Background()))
+	assert.Less(t, time.Since(shutdownStart), defs.ForwarderBatchSendTimeoutBase)
+	assert.Nil(t, <-served)
+	assert.Nil(t, srv.Err())
+	msg, ok := <-recv.ch
+	assert.True(t, ok)
+	assert.Equal(t, "hello", msg.Tag)
+	_, ok = <-recv.ch
+	assert.False(t, ok, "receiver ended")
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// cancelling Serve closes connections immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	srv, err = NewServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+	}, &clientMessageCollector{make(chan receivers.ClientMessage, 10)})
+	assert.Nil(t, err)
+	go func() { served <- srv.Serve(ctx) }()
+	conn2, dialErr := net.Dial("tcp", srv.Addr().String())
+	assert.Nil(t, dialErr)
+	defer conn2.Close()
+	cancel()
+	assert.ErrorIs(t, <-served, context.Canceled)
+	assert.ErrorIs(t, srv.Err(), context.Canceled)
+	_, err = conn2.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// receiver errors stop the server instead of exiting
+	failing := &failingReceiver{make(chan struct{})}
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+	}, failing)
+	conn3, dialErr := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, dialErr)
+	defer conn3.Close()
+	_, err = conn3.Write([]byte(`["hello", 1604106123, {"field1": "foo"}]`))
+	assert.Nil(t, err)
+	<-srv.Done()
+	<-failing.ended
+	assert.ErrorContains(t, srv.Err(), "receiver: failed to accept message: disk full")
+	assert.Nil(t, srv.Shutdown(context.Background()))
+
+	// Shutdown right after LaunchServer still ends the receiver
+	for i := 0; i < 10; i++ {
+		recv := &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+		srv, _ := LaunchServer(logger.WithField("test", t.Name()), Config{
+			ListenerConfig: ListenerConfig{
+				Address: "localhost:0",
+			},
+		}, recv)
+		assert.Nil(t, srv.Shutdown(context.Background()))
+		_, ok := <-recv.ch
+		assert.False(t, ok, "receiver ended")
+	}
+
+	// Shutdown returns by ctx even if the client never reads acks, which ends the acknowledger by timeout
+	recv = &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+	go func() {
+		for range recv.ch {
+		}
+	}()
+	srv, srvAddr = LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+		AckTimeout: 100 * time.Millisecond,
+	}, recv)
+	conn4, dialErr := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, dialErr)
+	defer conn4.Close()
+	request := []byte(`["hello", 1604106123, {"field1": "foo"}, {"chunk": "` + strings.Repeat("c", 1000) + `"}]`)
+	assert.Nil(t, conn4.SetWriteDeadline(time.Now().Add(3*time.Second)))
+	_, err = conn4.Write(bytes.Repeat(request, 20000))
+	assert.Error(t, err, "server stopped reading")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancelShutdown()
+	shutdownResult := make(chan error, 1)
+	go func() { shutdownResult <- srv.Shutdown(shutdownCtx) }()
+	select {
+	case <-shutdownResult:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown hangs after ctx is done")
 	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+		},
+		IdleTimeout: 500 * time.Millisecond,
+		ReadTimeout: 100 * time.Millisecond,
+	}, &clientMessageCollector{make(chan receivers.ClientMessage, 10)})
+	defer srv.Shutdown(context.Background())
+
+	// waits between requests are limited by idle timeout instead of read timeout
+	conn, err := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	jdecoder := json.NewDecoder(conn)
+	for _, chunkID := range []string{"c1", "c2"} {
+		_, err = conn.Write([]byte(`["hello", 1604106123, {"field1": "foo"}, {"chunk": "` + chunkID + `"}]`))
+		assert.Nil(t, err)
+		ack := forwardprotocol.Ack{}
+		assert.Nil(t, jdecoder.Decode(&ack))
+		assert.Equal(t, chunkID, ack.Ack)
+		time.Sleep(200 * time.Millisecond)
+	}
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// incomplete requests are limited by read timeout
+	conn2, err := net.Dial("tcp", srvAddr.String())
+	assert.Nil(t, err)
+	defer conn2.Close()
+	_, err = conn2.Write([]byte(`["hello", 160410`))
+	assert.Nil(t, err)
+	start = time.Now()
+	_, err = conn2.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestServerHandshakeTimeoutAndStall(t *testing.T) {
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address: "localhost:0",
+			Secret:  "hi",
+		},
+		Listeners: []ListenerConfig{
+			{
+				Name:                   "stall",
+				Address:                "localhost:0",
+				RandomNoHandshake:      1.0,
+				RandomNoHandshakeStall: 200 * time.Millisecond,
+				RandomStallJitter:      0.5,
+			},
+		},
+		HandshakeTimeout: 100 * time.Millisecond,
+	}, &clientMessageCollector{make(chan receivers.ClientMessage, 10)})
+	defer srv.Shutdown(context.Background())
 
-	if conn != nil {
+	for _, addr := range []net.Addr{srvAddr, srv.ListenerAddr("stall")} {
+		conn, err := net.Dial("tcp", addr.String())
+		assert.Nil(t, err)
+		start := time.Now()
+		_, err = io.ReadAll(conn)
+		assert.Nil(t, err, "read until EOF")
+		assert.Less(t, time.Since(start), 2*time.Second, addr.String())
 		conn.Close()
 	}
 
-	srv.Shutdown()
+	lsnr := &forwardListener{config: ListenerConfig{RandomStallJitter: 0.5}}
+	for i := 0; i < 100; i++ {
+		stall := lsnr.stallDuration(&connFaults{rand: newRand(1, int64(i))}, time.Second)
+		assert.GreaterOrEqual(t, stall, 500*time.Millisecond)
+		assert.LessOrEqual(t, stall, 1500*time.Millisecond)
+	}
+	_, err := NewServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address:           "localhost:0",
+			RandomStallJitter: 1.5,
+		},
+	}, &clientMessageCollector{nil})
+	assert.ErrorContains(t, err, "random stall jitter: 1.500000 is out of range")
 }
 
 func send(connHolder *net.Conn, addr string, secret string, data []byte) error {
@@ -169,14 +810,25 @@ func openConn(addr string, secret string) (net.Conn, error) {
 
 	conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
 
-	success, reason, netErr := forwardprotocol.DoClientHandshake(conn, secret, 5*time.Second)
-	if !success {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := forwardprotocol.DoClientHandshake(ctx, conn, forwardprotocol.ClientHandshakeOptions{SharedKey: secret}); err != nil {
 		conn.Close()
-		if netErr != nil {
-			return nil, netErr
-		}
-		return nil, errors.New(reason)
+		return nil, err
 	}
 
 	return conn, nil
 }
+
+// sendTestEvent sends one event by a new client of the given config, with ack required
+func sendTestEvent(t *testing.T, config forwardprotocol.ClientConfig, tag string, record map[string]interface{}) error {
+	config.RequireAck = true
+	client := forwardprotocol.NewForwardClient(logger.WithField("test", t.Name()), config)
+	defer client.Close()
+	return client.Send(tag, []forwardprotocol.EventEntry{
+		{
+			Time:   forwardprotocol.EventTime{Time: time.Date(2020, 10, 31, 1, 2, 3, 4, time.UTC)},
+			Record: record,
+		},
+	})
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// loadTenants builds the tenants table (tenant name to shared key) from Config.Tenants
+//
+// Tenant names and shared keys must be unique, including Config.Secret, so that each client is identified as one tenant
+func loadTenants(config ListenerConfig) (map[string]string, error) {
+	tenants := make(map[string]string)
+	keyToTenant := make(map[string]string)
+	if len(config.Secret) > 0 {
+		keyToTenant[config.Secret] = "secret"
+	}
+	for _, entry := range config.Tenants {
+		tenant, sharedKey, found := strings.Cut(entry, ":")
+		if !found || len(tenant) == 0 || len(sharedKey) == 0 {
+			return nil, fmt.Errorf("invalid tenant entry, should be 'tenant:shared_key': '%s'", entry)
+		}
+		if _, exists := tenants[tenant]; exists {
+			return nil, fmt.Errorf("duplicate tenant '%s'", tenant)
+		}
+		if other, exists := keyToTenant[sharedKey]; exists {
+			return nil, fmt.Errorf("shared key of tenant '%s' is already used by '%s'", tenant, other)
+		}
+		tenants[tenant] = sharedKey
+		keyToTenant[sharedKey] = tenant
+	}
+	return tenants, nil
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTenants(t *testing.T) {
+	tenants, err := loadTenants(ListenerConfig{Tenants: []string{"fleet-a:key-a", "fleet-b:key:b"}})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"fleet-a": "key-a", "fleet-b": "key:b"}, tenants)
+
+	errorTenants := map[string][]string{
+		"invalid tenant entry, should be 'tenant:shared_key': ':key-a'":  {":key-a"},
+		"invalid tenant entry, should be 'tenant:shared_key': 'fleet-a'": {"fleet-a"},
+		"duplicate tenant 'fleet-a'":                                     {"fleet-a:key-a", "fleet-a:key-b"},
+		"shared key of tenant 'fleet-b' is already used by 'fleet-a'":    {"fleet-a:key-a", "fleet-b:key-a"},
+		"shared key of tenant 'fleet-a' is already used by 'secret'":     {"fleet-a:hi"},
+	}
+	for expectedErr, entries := range errorTenants {
+		_, err := loadTenants(ListenerConfig{Secret: "hi", Tenants: entries})
+		assert.EqualError(t, err, expectedErr)
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
+)
+
+// Files written to Config.TLSTestCADir
+const (
+	testCACertFilename     = "ca.crt"
+	testClientCertFilename = "client.crt"
+	testClientKeyFilename  = "client.key"
+)
+
+// testCAName is the common name of the test CA
+const testCAName = "fluentlib test CA"
+
+// testCA is an ephemeral certificate authority to issue certificates for testing
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// testCertificate is a certificate issued by testCA in both PEM and tls.Certificate forms
+type testCertificate struct {
+	certPEM []byte
+	keyPEM  []byte
+	tlsCert tls.Certificate
+}
+
+// newTestCA creates a CA valid from one hour ago for a day
+func newTestCA(name string) (*testCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"fluentlib"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	return &testCA{cert: cert, key: key}, nil
+}
+
+// certPEM returns the CA certificate in PEM
+func (ca *testCA) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// issue creates a certificate for the given hosts (DNS names or IPs) and usage, valid in the given period
+func (ca *testCA) issue(commonName string, hosts []string, usage x509.ExtKeyUsage, notBefore, notAfter time.Time) (testCertificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return testCertificate{}, fmt.Errorf("generate key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"fluentlib"}},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return testCertificate{}, fmt.Errorf("create certificate: %w", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return testCertificate{}, fmt.Errorf("marshal key: %w", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return testCertificate{}, fmt.Errorf("load key pair: %w", err)
+	}
+	return testCertificate{certPEM: certPEM, keyPEM: keyPEM, tlsCert: tlsCert}, nil
+}
+
+// makeTestCACertificate issues the server certificate by Config.TLSTestCA and TLSFault from the given CA shared by
+// listeners, or a new CA if nil
+//
+// The CA and optional client certificate are written to Config.TLSTestCADir if set
+func makeTestCACertificate(config ListenerConfig, ca *testCA) (tls.Certificate, error) {
+	var err error
+	if ca == nil {
+		if ca, err = newTestCA(testCAName); err != nil {
+			return tls.Certificate{}, fmt.Errorf("CA: %w", err)
+		}
+	}
+	if len(config.TLSTestCADir) > 0 {
+		if err := writeTestCAFiles(ca, config); err != nil {
+			return tls.Certificate{}, fmt.Errorf("write to %s: %w", config.TLSTestCADir, err)
+		}
+	}
+
+	hosts := listAddressHosts(config.Address)
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)
+	switch config.TLSFault {
+	case tlsFaultExpired:
+		notBefore, notAfter = time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour)
+	case tlsFaultWrongSAN:
+		hosts = []string{"wrong-san.invalid"}
+	case tlsFaultUntrusted:
+		if ca, err = newTestCA("fluentlib untrusted CA"); err != nil {
+			return tls.Certificate{}, fmt.Errorf("untrusted CA: %w", err)
+		}
+	}
+	serverCert, err := ca.issue(hosts[0], hosts, x509.ExtKeyUsageServerAuth, notBefore, notAfter)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("server certificate: %w", err)
+	}
+	return serverCert.tlsCert, nil
+}
+
+func writeTestCAFiles(ca *testCA, config ListenerConfig) error {
+	if err := os.MkdirAll(config.TLSTestCADir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(config.TLSTestCADir, testCACertFilename), ca.certPEM(), 0644); err != nil {
+		return err
+	}
+	if len(config.TLSTestClientName) == 0 {
+		return nil
+	}
+	clientCert, err := ca.issue(config.TLSTestClientName, nil, x509.ExtKeyUsageClientAuth, time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour))
+	if err != nil {
+		return fmt.Errorf("client certificate: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(config.TLSTestCADir, testClientCertFilename), clientCert.certPEM, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(config.TLSTestCADir, testClientKeyFilename), clientCert.keyPEM, 0600)
+}
+
+// listAddressHosts lists the names and IPs to be put in the server certificate for the given listening address
+//
+// Local hostname and loopback addresses are included for addresses without a specific host, e.g. ":24224", or Unix
+// sockets
+func listAddressHosts(address string) []string {
+	network, address := forwardprotocol.ParseAddress(address)
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if ip := net.ParseIP(host); network != "unix" && host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return []string{host}
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	return hosts
+}
+
+func newSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
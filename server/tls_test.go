@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/relex/fluentlib/protocol/forwardprotocol"
+	"github.com/relex/fluentlib/server/receivers"
+	"github.com/relex/gotils/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// clientMessageCollector is a Receiver to collect messages with client information
+type clientMessageCollector struct {
+	ch chan receivers.ClientMessage
+}
+
+func (c *clientMessageCollector) Accept(message receivers.ClientMessage) error {
+	c.ch <- message
+	return nil
+}
+
+func (c *clientMessageCollector) Tick() error {
+	return nil
+}
+
+func (c *clientMessageCollector) End() error {
+	close(c.ch)
+	return nil
+}
+
+// writeTestCertificate writes the PEM files of the certificate and returns (cert path, key path)
+func writeTestCertificate(t *testing.T, dir string, name string, cert testCertificate) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	assert.Nil(t, os.WriteFile(certFile, cert.certPEM, 0600))
+	assert.Nil(t, os.WriteFile(keyFile, cert.keyPEM, 0600))
+	return certFile, keyFile
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	dirPath, dirErr := os.MkdirTemp("", "fluentlib-tls-test-*")
+	assert.Nil(t, dirErr)
+	defer os.RemoveAll(dirPath)
+
+	ca, caErr := newTestCA("test-ca")
+	assert.Nil(t, caErr)
+	caFile := filepath.Join(dirPath, "ca.crt")
+	assert.Nil(t, os.WriteFile(caFile, ca.certPEM(), 0600))
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	serverCert, serverErr := ca.issue("localhost", []string{"localhost", "127.0.0.1"}, x509.ExtKeyUsageServerAuth, notBefore, notAfter)
+	assert.Nil(t, serverErr)
+	serverCertFile, serverKeyFile := writeTestCertificate(t, dirPath, "server", serverCert)
+	clientCert, clientErr := ca.issue("agent-1", nil, x509.ExtKeyUsageClientAuth, notBefore, notAfter)
+	assert.Nil(t, clientErr)
+
+	recv := &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+	srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+		ListenerConfig: ListenerConfig{
+			Address:         "localhost:0",
+			TLS:             true,
+			TLSCertFile:     serverCertFile,
+			TLSKeyFile:      serverKeyFile,
+			TLSClientCAFile: caFile,
+			TLSMaxVersion:   "1.2",
+		},
+	}, recv)
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.cert)
+	clientKeyPair := clientCert.tlsCert
+	send := func(tlsConfig *tls.Config) error {
+		return sendTestEvent(t, forwardprotocol.ClientConfig{
+			Address:   srvAddr.String(),
+			TLS:       true,
+			TLSConfig: tlsConfig,
+		}, "hello", map[string]interface{}{"field1": "foo"})
+	}
+
+	assert.Error(t, send(&tls.Config{RootCAs: rootCAs, ServerName: "localhost"}), "without client certificate")
+	assert.Error(t, send(&tls.Config{RootCAs: rootCAs, ServerName: "localhost", Certificates: []tls.Certificate{clientKeyPair}, MinVersion: tls.VersionTLS13}), "TLS 1.3 only")
+	assert.Nil(t, send(&tls.Config{RootCAs: rootCAs, ServerName: "localhost", Certificates: []tls.Certificate{clientKeyPair}}))
+
+	msg := <-recv.ch
+	assert.Equal(t, "CN=agent-1,O=fluentlib", msg.ClientSubject)
+	assert.Equal(t, "hello", msg.Tag)
+
+	srv.Shutdown(context.Background())
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	tlsConfig, err := loadTLSConfig(ListenerConfig{
+		TLS:             true,
+		TLSMinVersion:   "1.1",
+		TLSMaxVersion:   "1.2",
+		TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "tls_rsa_with_aes_128_cbc_sha"},
+	}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS11), tlsConfig.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MaxVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA}, tlsConfig.CipherSuites)
+	assert.Len(t, tlsConfig.Certificates, 1)
+
+	_, err = loadTLSConfig(ListenerConfig{TLS: true, TLSMinVersion: "1.4"}, nil)
+	assert.ErrorContains(t, err, "min version: unknown '1.4'")
+	_, err = loadTLSConfig(ListenerConfig{TLS: true, TLSCipherSuites: []string{"TLS_FOO"}}, nil)
+	assert.ErrorContains(t, err, "cipher suites: unknown 'TLS_FOO'")
+	_, err = loadTLSConfig(ListenerConfig{TLS: true, TLSCertFile: "/nonexistent.crt", TLSKeyFile: "/nonexistent.key"}, nil)
+	assert.ErrorContains(t, err, "certificate: ")
+
+	tlsConfig, err = loadTLSConfig(ListenerConfig{TLS: false}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+}
+
+func TestServerTestCA(t *testing.T) {
+	dirPath, dirErr := os.MkdirTemp("", "fluentlib-testca-test-*")
+	assert.Nil(t, dirErr)
+	defer os.RemoveAll(dirPath)
+
+	launch := func(fault string) (*ForwardServer, string, string, chan receivers.ClientMessage) {
+		recv := &clientMessageCollector{make(chan receivers.ClientMessage, 10)}
+		caDir := filepath.Join(dirPath, "ca-"+fault)
+		srv, srvAddr := LaunchServer(logger.WithField("test", t.Name()), Config{
+			ListenerConfig: ListenerConfig{
+				Address:           "localhost:0",
+				TLS:               true,
+				TLSClientCAFile:   filepath.Join(caDir, testCACertFilename),
+				TLSTestCA:         true,
+				TLSTestCADir:      caDir,
+				TLSTestClientName: "agent-2",
+				TLSFault:          fault,
+			},
+			ListenerSpecs: []string{"listener_name=second address=localhost:0"},
+		}, recv)
+		return srv, srvAddr.String(), caDir, recv.ch
+	}
+	send := func(srvAddr string, caDir string) error {
+		caPem, readErr := os.ReadFile(filepath.Join(caDir, testCACertFilename))
+		assert.Nil(t, readErr)
+		rootCAs := x509.NewCertPool()
+		assert.True(t, rootCAs.AppendCertsFromPEM(caPem))
+		clientKeyPair, pairErr := tls.LoadX509KeyPair(filepath.Join(caDir, testClientCertFilename), filepath.Join(caDir, testClientKeyFilename))
+		assert.Nil(t, pairErr)
+
+		return sendTestEvent(t, forwardprotocol.ClientConfig{
+			Address:   srvAddr,
+			TLS:       true,
+			TLSConfig: &tls.Config{RootCAs: rootCAs, ServerName: "localhost", Certificates: []tls.Certificate{clientKeyPair}},
+		}, "hello", map[string]interface{}{"field1": "foo"})
+	}
+
+	srv, srvAddr, caDir, ch := launch("")
+	assert.Nil(t, send(srvAddr, caDir))
+	msg := <-ch
+	assert.Equal(t, "CN=agent-2,O=fluentlib", msg.ClientSubject)
+	// the CA is shared by listeners, so the written files work for all of them
+	assert.Nil(t, send(srv.ListenerAddr("second").String(), caDir))
+	msg = <-ch
+	assert.Equal(t, "second", msg.Listener)
+	srv.Shutdown(context.Background())
+
+	faults := map[string]string{
+		tlsFaultExpired:   "certificate has expired",
+		tlsFaultWrongSAN:  "valid for wrong-san.invalid, not localhost",
+		tlsFaultUntrusted: "unknown authority",
+		tlsFaultAbort:     "EOF",
+	}
+	for fault, expectedErr := range faults {
+		srv, srvAddr, caDir, _ := launch(fault)
+		assert.ErrorContains(t, send(srvAddr, caDir), expectedErr, fault)
+		srv.Shutdown(context.Background())
+	}
+
+	_, err := loadTLSConfig(ListenerConfig{TLS: true, TLSFault: tlsFaultExpired}, nil)
+	assert.ErrorContains(t, err, "fault 'expired' requires the test CA")
+	_, err = loadTLSConfig(ListenerConfig{TLS: true, TLSFault: "boom"}, nil)
+	assert.ErrorContains(t, err, "unknown fault 'boom'")
+}
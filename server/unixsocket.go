@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenUnix listens to the given Unix socket path, or abstract socket if the path starts with '@'
+//
+// A stale socket file left by previous runs is removed, but not one in use. The socket file is removed by the
+// listener on close.
+func listenUnix(path string, mode string) (net.Listener, error) {
+	abstract := strings.HasPrefix(path, "@")
+	if !abstract {
+		if err := removeStaleUnixSocket(path); err != nil {
+			return nil, err
+		}
+	}
+	lsnr, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if len(mode) > 0 && !abstract {
+		perm, parseErr := strconv.ParseUint(mode, 8, 32)
+		if parseErr != nil {
+			lsnr.Close()
+			return nil, fmt.Errorf("invalid mode '%s': %w", mode, parseErr)
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			lsnr.Close()
+			return nil, fmt.Errorf("chmod: %w", err)
+		}
+	}
+	return lsnr, nil
+}
+
+func removeStaleUnixSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
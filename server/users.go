@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadUsers builds the users table (username to password) from Config.Users and Config.UsersFile
+//
+// Usernames must be unique across both sources
+func loadUsers(config ListenerConfig) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, entry := range config.Users {
+		if err := addUserEntry(users, entry); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.UsersFile) > 0 {
+		if err := loadUsersFile(users, config.UsersFile); err != nil {
+			return nil, fmt.Errorf("users file %s: %w", config.UsersFile, err)
+		}
+	}
+	return users, nil
+}
+
+// loadUsersFile reads "username:password" lines from the given file. Empty lines and lines starting with '#' are ignored.
+func loadUsersFile(users map[string]string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := addUserEntry(users, line); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func addUserEntry(users map[string]string, entry string) error {
+	username, password, found := strings.Cut(entry, ":")
+	if !found || len(username) == 0 {
+		return fmt.Errorf("invalid user entry, should be 'username:password': '%s'", entry)
+	}
+	if _, exists := users[username]; exists {
+		return fmt.Errorf("duplicate user '%s'", username)
+	}
+	users[username] = password
+	return nil
+}
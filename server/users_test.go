@@ -0,0 +1,28 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadUsers(t *testing.T) {
+	dirPath, dirErr := os.MkdirTemp("", "fluentlib-users-test-*")
+	assert.Nil(t, dirErr)
+	defer os.RemoveAll(dirPath)
+
+	usersFile := filepath.Join(dirPath, "users")
+	assert.Nil(t, os.WriteFile(usersFile, []byte("# comment\n\nbob:pass:word\n"), 0600))
+	users, err := loadUsers(ListenerConfig{Users: []string{"alice:secret"}, UsersFile: usersFile})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"alice": "secret", "bob": "pass:word"}, users)
+
+	_, err = loadUsers(ListenerConfig{Users: []string{"alice:secret", ":secret"}})
+	assert.EqualError(t, err, "invalid user entry, should be 'username:password': ':secret'")
+	_, err = loadUsers(ListenerConfig{Users: []string{"alice"}})
+	assert.EqualError(t, err, "invalid user entry, should be 'username:password': 'alice'")
+	_, err = loadUsers(ListenerConfig{Users: []string{"bob:other"}, UsersFile: usersFile})
+	assert.EqualError(t, err, "users file "+usersFile+": line 3: duplicate user 'bob'")
+}
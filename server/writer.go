@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/relex/fluentlib/server/receivers"
@@ -8,7 +9,11 @@ import (
 	"github.com/relex/gotils/logger"
 )
 
-func launchWriter(wlogger logger.Logger, receiver receivers.Receiver) (chan<- receivers.ClientMessage, channels.Awaitable) {
+// launchWriter passes messages from the returned channel to receiver in background, until the channel is closed
+//
+// The first error from receiver is reported to onError, after which messages are discarded. The receiver is always
+// ended.
+func launchWriter(wlogger logger.Logger, receiver receivers.Receiver, onError func(error)) (chan<- receivers.ClientMessage, channels.Awaitable) {
 	outputChan := make(chan receivers.ClientMessage, 1000)
 	endsignal := channels.NewSignalAwaitable()
 
@@ -17,6 +22,15 @@ func launchWriter(wlogger logger.Logger, receiver receivers.Receiver) (chan<- re
 
 		numMessage := 0
 		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		failed := false
+		fail := func(err error) {
+			wlogger.Error(err)
+			if !failed {
+				failed = true
+				onError(err)
+			}
+		}
 
 	RECEIVE_LOOP:
 		for {
@@ -25,19 +39,25 @@ func launchWriter(wlogger logger.Logger, receiver receivers.Receiver) (chan<- re
 				if !ok {
 					break RECEIVE_LOOP
 				}
+				if failed {
+					continue
+				}
 				numMessage++
 				if err := receiver.Accept(message); err != nil {
-					wlogger.Fatalf("failed to accept message: %v", err)
+					fail(fmt.Errorf("failed to accept message: %w", err))
 				}
 			case <-ticker.C:
+				if failed {
+					continue
+				}
 				if err := receiver.Tick(); err != nil {
-					wlogger.Fatalf("failed to tick: %v", err)
+					fail(fmt.Errorf("failed to tick: %w", err))
 				}
 			}
 		}
 
 		if err := receiver.End(); err != nil {
-			wlogger.Fatalf("failed to close receiver: %v", err)
+			fail(fmt.Errorf("failed to close receiver: %w", err))
 		}
 		wlogger.Infof("written %d log records", numMessage)
 	}()
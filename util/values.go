@@ -0,0 +1,62 @@
+package util
+
+// ToInt64 converts a generic integer value, e.g. decoded from msgpack into interface{}, to int64
+//
+// Returns (result, is integer?)
+func ToInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// ToFloat64 converts a generic integer or float value, e.g. decoded from msgpack into interface{}, to float64
+//
+// Returns (result, is number?)
+func ToFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case uint64:
+		return float64(v), true
+	default:
+		i, ok := ToInt64(value)
+		return float64(i), ok
+	}
+}
+
+// ToUint64 converts a generic non-negative integer value, e.g. decoded from msgpack into interface{}, to uint64
+//
+// Returns (result, is non-negative integer?)
+func ToUint64(value interface{}) (uint64, bool) {
+	if v, ok := value.(uint64); ok {
+		return v, true
+	}
+	i, ok := ToInt64(value)
+	if !ok || i < 0 {
+		return 0, false
+	}
+	return uint64(i), true
+}